@@ -0,0 +1,63 @@
+package annotations
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxNoteLength = 2000
+	maxTags       = 20
+	maxTagLength  = 32
+)
+
+// ErrInvalid marks annotations that cannot be stored as given
+var ErrInvalid = errors.New("invalid annotation")
+
+// tagPattern limits tags to characters S3 accepts in object tag values, so
+// every store can hold them
+var tagPattern = regexp.MustCompile(`^[a-z0-9_.:/=+@-]+$`)
+
+// Annotation is what a user recorded against a clip
+type Annotation struct {
+	Note      string     `json:"note"`
+	Tags      []string   `json:"tags"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// IsEmpty reports whether the annotation holds nothing, in which case it is
+// removed rather than stored
+func (a Annotation) IsEmpty() bool {
+	return a.Note == "" && len(a.Tags) == 0
+}
+
+// normalize trims the note and lowercases, deduplicates and sorts the tags,
+// rejecting values that are too long or use characters stores cannot hold
+func (a Annotation) normalize() (Annotation, error) {
+	out := Annotation{Note: strings.TrimSpace(a.Note), Tags: []string{}}
+	if len(out.Note) > maxNoteLength {
+		return out, fmt.Errorf("%w: note is longer than %d characters", ErrInvalid, maxNoteLength)
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range a.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+			return out, fmt.Errorf("%w: tag %q (use up to %d letters, digits and _ . : / = + @ -)", ErrInvalid, tag, maxTagLength)
+		}
+		seen[tag] = true
+		out.Tags = append(out.Tags, tag)
+	}
+	if len(out.Tags) > maxTags {
+		return out, fmt.Errorf("%w: a clip can have at most %d tags", ErrInvalid, maxTags)
+	}
+	sort.Strings(out.Tags)
+	return out, nil
+}
@@ -0,0 +1,143 @@
+package annotations
+
+import (
+	"camera-viewer/clipindex"
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Stores
+const (
+	StoreLocal = "local"
+	StoreS3    = "s3"
+)
+
+// Service reads and writes clip annotations and mirrors them into the clip
+// index, which serves them in listings and search
+type Service struct {
+	store store
+	local *localStore
+	index *clipindex.Index
+}
+
+func New(cfg config.AnnotationsConfig, s3Service *services.S3Service, index *clipindex.Index) (*Service, error) {
+	s := &Service{index: index}
+	switch cfg.Store {
+	case StoreLocal:
+		local, err := openLocalStore(cfg.DBPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open annotations database: %w", err)
+		}
+		s.store = local
+		s.local = local
+	case StoreS3:
+		s.store = &s3Store{s3Service: s3Service}
+		index.OnAdded(s.indexTags)
+	default:
+		return nil, fmt.Errorf("invalid ANNOTATIONS_STORE %q (use local or s3)", cfg.Store)
+	}
+	return s, nil
+}
+
+// Start copies local annotations into the clip index, which may have been
+// rebuilt since they were written. Annotations kept as S3 object tags are
+// read as clips are added to the index, and mirrored as they are written.
+func (s *Service) Start(ctx context.Context) error {
+	if s.local == nil {
+		return nil
+	}
+
+	all, err := s.local.list(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load annotations: %w", err)
+	}
+	for key, a := range all {
+		if err := s.index.SetAnnotation(ctx, key, a.Note, a.Tags); err != nil {
+			return err
+		}
+	}
+	if len(all) > 0 {
+		slog.Info("Loaded clip annotations into the clip index", "count", len(all))
+	}
+	return nil
+}
+
+// indexTags reads the object tags of clips new to the index, so their
+// annotations can be searched without opening each clip first
+func (s *Service) indexTags(ctx context.Context, keys []string) {
+	indexed := 0
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		a, err := s.store.get(ctx, key)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read annotation", "key", key, "error", err)
+			continue
+		}
+		if a.IsEmpty() {
+			continue
+		}
+		if err := s.index.SetAnnotation(ctx, key, a.Note, a.Tags); err != nil {
+			slog.WarnContext(ctx, "Failed to index annotation", "key", key, "error", err)
+			continue
+		}
+		indexed++
+	}
+	if indexed > 0 {
+		slog.DebugContext(ctx, "Indexed annotations from object tags", "count", indexed)
+	}
+}
+
+func (s *Service) Close() error {
+	if s.local != nil {
+		return s.local.close()
+	}
+	return nil
+}
+
+// Ping checks that the local annotations database can be read. Annotations
+// kept as S3 object tags need only the bucket.
+func (s *Service) Ping(ctx context.Context) error {
+	if s.local == nil {
+		return nil
+	}
+	return s.local.ping(ctx)
+}
+
+// Get returns a clip's annotation, empty if it has none
+func (s *Service) Get(ctx context.Context, key string) (Annotation, error) {
+	a, err := s.store.get(ctx, key)
+	if err != nil {
+		return a, err
+	}
+	if err := s.index.SetAnnotation(ctx, key, a.Note, a.Tags); err != nil {
+		slog.WarnContext(ctx, "Failed to index annotation", "key", key, "error", err)
+	}
+	return a, nil
+}
+
+// Set replaces a clip's annotation and returns it as stored. An empty note
+// and tag list removes it.
+func (s *Service) Set(ctx context.Context, key string, a Annotation) (Annotation, error) {
+	a, err := a.normalize()
+	if err != nil {
+		return a, err
+	}
+	if !a.IsEmpty() {
+		now := time.Now().Truncate(time.Second)
+		a.UpdatedAt = &now
+	}
+
+	if err := s.store.set(ctx, key, a); err != nil {
+		return a, err
+	}
+	if err := s.index.SetAnnotation(ctx, key, a.Note, a.Tags); err != nil {
+		return a, fmt.Errorf("saved, but failed to index the annotation: %w", err)
+	}
+	return a, nil
+}
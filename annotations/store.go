@@ -0,0 +1,172 @@
+package annotations
+
+import (
+	"camera-viewer/services"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// store keeps annotations. An empty annotation removes the clip's entry.
+type store interface {
+	get(ctx context.Context, key string) (Annotation, error)
+	set(ctx context.Context, key string, a Annotation) error
+}
+
+// localStore keeps annotations in a SQLite database next to the server
+type localStore struct {
+	db *sql.DB
+}
+
+func openLocalStore(path string) (*localStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS annotations (
+		key TEXT PRIMARY KEY,
+		note TEXT NOT NULL,
+		tags TEXT NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create annotations table: %w", err)
+	}
+	return &localStore{db: db}, nil
+}
+
+func (s *localStore) get(ctx context.Context, key string) (Annotation, error) {
+	var note, tags string
+	var updated int64
+	err := s.db.QueryRowContext(ctx, "SELECT note, tags, updated_at FROM annotations WHERE key = ?", key).Scan(&note, &tags, &updated)
+	if err == sql.ErrNoRows {
+		return Annotation{Tags: []string{}}, nil
+	}
+	if err != nil {
+		return Annotation{}, err
+	}
+	t := time.Unix(updated, 0)
+	return Annotation{Note: note, Tags: splitTags(tags), UpdatedAt: &t}, nil
+}
+
+func (s *localStore) set(ctx context.Context, key string, a Annotation) error {
+	if a.IsEmpty() {
+		_, err := s.db.ExecContext(ctx, "DELETE FROM annotations WHERE key = ?", key)
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO annotations (key, note, tags, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET note = excluded.note, tags = excluded.tags, updated_at = excluded.updated_at`,
+		key, a.Note, strings.Join(a.Tags, " "), a.UpdatedAt.Unix(),
+	)
+	return err
+}
+
+// list returns every stored annotation by clip key
+func (s *localStore) list(ctx context.Context) (map[string]Annotation, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT key, note, tags, updated_at FROM annotations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := make(map[string]Annotation)
+	for rows.Next() {
+		var key, note, tags string
+		var updated int64
+		if err := rows.Scan(&key, &note, &tags, &updated); err != nil {
+			return nil, err
+		}
+		t := time.Unix(updated, 0)
+		all[key] = Annotation{Note: note, Tags: splitTags(tags), UpdatedAt: &t}
+	}
+	return all, rows.Err()
+}
+
+func (s *localStore) ping(ctx context.Context) error {
+	var count int
+	return s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM annotations").Scan(&count)
+}
+
+func (s *localStore) close() error {
+	return s.db.Close()
+}
+
+// S3 object tags holding annotations. Other tags on an object are kept.
+const (
+	noteTag    = "camera-viewer-note"
+	tagsTag    = "camera-viewer-tags"
+	updatedTag = "camera-viewer-updated"
+	// maxTagValue is the longest value S3 accepts for an object tag
+	maxTagValue = 256
+)
+
+// s3Store keeps annotations as S3 object tags on the clips themselves, so
+// they travel with copies and are visible to other tools. Tag values only
+// allow a few characters, so the note is stored base64url encoded.
+type s3Store struct {
+	s3Service *services.S3Service
+}
+
+func (s *s3Store) get(ctx context.Context, key string) (Annotation, error) {
+	tags, err := s.s3Service.GetObjectTags(ctx, key)
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	a := Annotation{Tags: splitTags(tags[tagsTag])}
+	if encoded := tags[noteTag]; encoded != "" {
+		note, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return Annotation{}, fmt.Errorf("invalid %s tag: %w", noteTag, err)
+		}
+		a.Note = string(note)
+	}
+	if updated, err := time.Parse(time.RFC3339, tags[updatedTag]); err == nil {
+		a.UpdatedAt = &updated
+	}
+	return a, nil
+}
+
+func (s *s3Store) set(ctx context.Context, key string, a Annotation) error {
+	note := base64.RawURLEncoding.EncodeToString([]byte(a.Note))
+	if len(note) > maxTagValue {
+		return fmt.Errorf("%w: note is too long to store as an S3 object tag (at most %d bytes)", ErrInvalid, maxTagValue*3/4)
+	}
+	joined := strings.Join(a.Tags, " ")
+	if len(joined) > maxTagValue {
+		return fmt.Errorf("%w: tags are too long to store as an S3 object tag (at most %d characters together)", ErrInvalid, maxTagValue)
+	}
+
+	tags, err := s.s3Service.GetObjectTags(ctx, key)
+	if err != nil {
+		return err
+	}
+	delete(tags, noteTag)
+	delete(tags, tagsTag)
+	delete(tags, updatedTag)
+	if !a.IsEmpty() {
+		if note != "" {
+			tags[noteTag] = note
+		}
+		if joined != "" {
+			tags[tagsTag] = joined
+		}
+		tags[updatedTag] = a.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return s.s3Service.PutObjectTags(ctx, key, tags)
+}
+
+func splitTags(s string) []string {
+	tags := strings.Fields(s)
+	if tags == nil {
+		tags = []string{}
+	}
+	return tags
+}
@@ -0,0 +1,302 @@
+package bulk
+
+import (
+	"camera-viewer/services"
+	"camera-viewer/trash"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// copyWorkers is the number of objects copied in parallel
+	copyWorkers = 8
+	// keepJobs is how many finished jobs are remembered for the API
+	keepJobs = 50
+	// trashBatch is how many objects are moved to the trash between
+	// progress updates
+	trashBatch = 100
+)
+
+// Job states
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var ErrNotFound = errors.New("bulk job not found")
+
+// Progress counts the objects handled so far
+type Progress struct {
+	Stage     string `json:"stage"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+}
+
+// Job is the state of one bulk operation as reported by the API
+type Job struct {
+	ID         string              `json:"id"`
+	Request    Request             `json:"request"`
+	Status     string              `json:"status"`
+	Progress   Progress            `json:"progress"`
+	Failures   []services.KeyError `json:"failures"`
+	Error      string              `json:"error,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+}
+
+// Manager runs bulk operations in the background. Jobs are held in memory
+// and are lost on restart.
+type Manager struct {
+	s3Service *services.S3Service
+	bin       *trash.Bin
+	ctx       context.Context
+	// wg tracks the running jobs
+	wg sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager(ctx context.Context, s3Service *services.S3Service, bin *trash.Bin) *Manager {
+	return &Manager{
+		s3Service: s3Service,
+		bin:       bin,
+		ctx:       ctx,
+		jobs:      make(map[string]*Job),
+	}
+}
+
+// Plan lists what a request would do without changing anything
+func (m *Manager) Plan(ctx context.Context, req Request) (*Plan, error) {
+	return plan(ctx, m.s3Service, req, m.bin.Prefix())
+}
+
+// Start validates a request and runs it in the background; poll Get for
+// progress
+func (m *Manager) Start(req Request) (Job, error) {
+	if err := req.validate(); err != nil {
+		return Job{}, err
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Job{}, fmt.Errorf("failed to generate job id: %w", err)
+	}
+
+	job := &Job{
+		ID:        hex.EncodeToString(b),
+		Request:   req,
+		Status:    StatusRunning,
+		Progress:  Progress{Stage: "listing"},
+		Failures:  []services.KeyError{},
+		CreatedAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.prune()
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run(job)
+	}()
+	return *job, nil
+}
+
+// Wait blocks until the running jobs have stopped, which they do once the
+// manager's context is done
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return m.snapshot(job), nil
+}
+
+// List returns all remembered jobs, newest first
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, m.snapshot(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// snapshot copies a job so callers can read it without the lock
+func (m *Manager) snapshot(job *Job) Job {
+	c := *job
+	c.Failures = append([]services.KeyError{}, job.Failures...)
+	return c
+}
+
+// prune forgets the oldest finished jobs beyond keepJobs
+func (m *Manager) prune() {
+	var finished []*Job
+	for _, job := range m.jobs {
+		if job.FinishedAt != nil {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= keepJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(*finished[j].FinishedAt)
+	})
+	for _, job := range finished[:len(finished)-keepJobs] {
+		delete(m.jobs, job.ID)
+	}
+}
+
+func (m *Manager) run(job *Job) {
+	err := m.execute(m.ctx, job)
+
+	now := time.Now()
+	m.mu.Lock()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = StatusDone
+	}
+	job.Progress.Stage = job.Status
+	progress := job.Progress
+	m.mu.Unlock()
+
+	slog.Info("Bulk job finished", "action", job.Request.Action, "job", job.ID, "status", job.Status, "succeeded", progress.Succeeded, "failed", progress.Failed)
+}
+
+func (m *Manager) execute(ctx context.Context, job *Job) error {
+	p, err := plan(ctx, m.s3Service, job.Request, m.bin.Prefix())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	job.Progress.Total = p.Count + len(p.Missing)
+	m.mu.Unlock()
+	m.record(job, 0, p.Missing)
+
+	switch {
+	case job.Request.trashes():
+		m.setStage(job, "trashing")
+		for start := 0; start < len(p.Items) && ctx.Err() == nil; start += trashBatch {
+			end := start + trashBatch
+			if end > len(p.Items) {
+				end = len(p.Items)
+			}
+			keys := make([]string, 0, end-start)
+			for _, item := range p.Items[start:end] {
+				keys = append(keys, item.Key)
+			}
+			trashed, failed := m.bin.Delete(ctx, keys)
+			m.record(job, len(trashed), failed)
+		}
+		return ctx.Err()
+	case job.Request.Action == ActionDelete:
+		keys := make([]string, 0, len(p.Items))
+		for _, item := range p.Items {
+			keys = append(keys, item.Key)
+		}
+		m.setStage(job, "deleting")
+		_, _, err := m.s3Service.DeleteObjects(ctx, keys, func(deleted []string, failed []services.KeyError) {
+			m.record(job, len(deleted), failed)
+		})
+		return err
+	default:
+		m.setStage(job, "copying")
+		copied := m.copyItems(ctx, job, p.Items, job.Request.Action == ActionCopy)
+		if job.Request.Action == ActionCopy {
+			return nil
+		}
+
+		// Sources are only deleted once their copy exists
+		m.setStage(job, "deleting")
+		_, _, err := m.s3Service.DeleteObjects(ctx, copied, func(deleted []string, failed []services.KeyError) {
+			for i := range failed {
+				failed[i].Error = "copied but not deleted: " + failed[i].Error
+			}
+			m.record(job, len(deleted), failed)
+		})
+		return err
+	}
+}
+
+// copyItems copies items in parallel and returns the keys that were copied.
+// When count is set, each successful copy counts as done; a move counts
+// its objects once the source is deleted.
+func (m *Manager) copyItems(ctx context.Context, job *Job, items []Item, count bool) []string {
+	work := make(chan Item)
+	var mu sync.Mutex
+	var copied []string
+
+	var wg sync.WaitGroup
+	for i := 0; i < copyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				if err := m.s3Service.CopyObject(ctx, item.Key, item.DestBucket, item.DestKey); err != nil {
+					m.record(job, 0, []services.KeyError{{Key: item.Key, Error: err.Error()}})
+					continue
+				}
+				mu.Lock()
+				copied = append(copied, item.Key)
+				mu.Unlock()
+				if count {
+					m.record(job, 1, nil)
+				}
+			}
+		}()
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+
+	return copied
+}
+
+func (m *Manager) setStage(job *Job, stage string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.Progress.Stage = stage
+}
+
+func (m *Manager) record(job *Job, succeeded int, failed []services.KeyError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.Progress.Succeeded += succeeded
+	job.Progress.Failed += len(failed)
+	job.Failures = append(job.Failures, failed...)
+}
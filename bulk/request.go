@@ -0,0 +1,225 @@
+package bulk
+
+import (
+	"camera-viewer/services"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Bulk actions
+const (
+	// ActionDelete moves objects to the trash, or deletes them for good
+	// when the request is permanent
+	ActionDelete = "delete"
+	ActionCopy   = "copy"
+	ActionMove   = "move"
+	// ActionTrash soft-deletes objects by moving them to the trash
+	ActionTrash = "trash"
+)
+
+// maxRangeDays bounds date range selections
+const maxRangeDays = 366
+
+// Request describes a bulk operation
+type Request struct {
+	Action      string       `json:"action"`
+	Selection   Selection    `json:"selection"`
+	Destination *Destination `json:"destination,omitempty"`
+	// Permanent deletes without going through the trash
+	Permanent bool `json:"permanent,omitempty"`
+	// DryRun only lists what the operation would do
+	DryRun bool `json:"dry_run"`
+}
+
+// Selection picks the objects to operate on. Exactly one of a date, a date
+// range, a prefix or a list of keys is set.
+type Selection struct {
+	Date      string   `json:"date,omitempty"`
+	StartDate string   `json:"start_date,omitempty"`
+	EndDate   string   `json:"end_date,omitempty"`
+	Prefix    string   `json:"prefix,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+}
+
+// Destination is where copy and move put objects. An empty bucket means
+// the camera-viewer bucket.
+type Destination struct {
+	Bucket string `json:"bucket,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// Item is one object in a plan
+type Item struct {
+	Key        string `json:"key"`
+	Size       int64  `json:"size,omitempty"`
+	DestBucket string `json:"dest_bucket,omitempty"`
+	DestKey    string `json:"dest_key,omitempty"`
+}
+
+// Plan lists the objects an operation affects
+type Plan struct {
+	Action     string `json:"action"`
+	Bucket     string `json:"bucket"`
+	Count      int    `json:"count"`
+	TotalBytes int64  `json:"total_bytes"`
+	Items      []Item `json:"items"`
+	// Missing are listed keys that do not exist, which are reported as
+	// failures rather than counted as deleted
+	Missing []services.KeyError `json:"missing"`
+}
+
+func (r Request) validate() error {
+	switch r.Action {
+	case ActionDelete, ActionTrash:
+		if r.Destination != nil {
+			return fmt.Errorf("%s does not take a destination", r.Action)
+		}
+	case ActionCopy, ActionMove:
+		if r.Destination == nil || (r.Destination.Bucket == "" && r.Destination.Prefix == "") {
+			return fmt.Errorf("%s requires a destination bucket or prefix", r.Action)
+		}
+	default:
+		return fmt.Errorf("invalid action %q (use trash, delete, copy or move)", r.Action)
+	}
+	if r.Permanent && r.Action != ActionDelete {
+		return fmt.Errorf("permanent only applies to delete")
+	}
+
+	sel := r.Selection
+	set := 0
+	if sel.Date != "" {
+		set++
+		if _, err := time.Parse("2006-01-02", sel.Date); err != nil {
+			return fmt.Errorf("invalid date format (use YYYY-MM-DD)")
+		}
+	}
+	if sel.StartDate != "" || sel.EndDate != "" {
+		set++
+		if _, _, err := sel.dateRange(); err != nil {
+			return err
+		}
+	}
+	if sel.Prefix != "" {
+		set++
+		// Like date ranges, a prefix is bounded; "2" would select years
+		if _, ok := services.DayOf(sel.Prefix, time.UTC); !ok {
+			return fmt.Errorf("prefix must be within a day, such as 2024/01/15/")
+		}
+	}
+	if len(sel.Keys) > 0 {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("select exactly one of date, start_date/end_date, prefix or keys")
+	}
+	return nil
+}
+
+// trashes reports whether the request moves objects to the trash
+func (r Request) trashes() bool {
+	return r.Action == ActionTrash || (r.Action == ActionDelete && !r.Permanent)
+}
+
+func (s Selection) dateRange() (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01-02", s.StartDate)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid start_date format (use YYYY-MM-DD)")
+	}
+	end, err := time.Parse("2006-01-02", s.EndDate)
+	if err != nil {
+		return start, end, fmt.Errorf("invalid end_date format (use YYYY-MM-DD)")
+	}
+	if end.Before(start) {
+		return start, end, fmt.Errorf("end_date must not be before start_date")
+	}
+	if end.Sub(start) > maxRangeDays*24*time.Hour {
+		return start, end, fmt.Errorf("date range cannot be longer than %d days", maxRangeDays)
+	}
+	return start, end, nil
+}
+
+// plan resolves a request's selection into the objects it affects
+func plan(ctx context.Context, s3Service *services.S3Service, req Request, trashPrefix string) (*Plan, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	sel := req.Selection
+	var objects []services.Video
+	missing := []services.KeyError{}
+	switch {
+	case len(sel.Keys) > 0:
+		// Listed keys are looked up, since S3 reports deleting a key that
+		// does not exist as a success
+		seen := make(map[string]bool)
+		for _, key := range sel.Keys {
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			object, err := s3Service.StatVideo(ctx, key)
+			if services.IsNotFound(err) {
+				missing = append(missing, services.KeyError{Key: key, Error: "object not found"})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+		}
+	default:
+		var prefixes []string
+		switch {
+		case sel.Prefix != "":
+			prefixes = []string{sel.Prefix}
+		case sel.Date != "":
+			day, _ := time.Parse("2006-01-02", sel.Date)
+			prefixes = []string{day.Format("2006/01/02/")}
+		default:
+			start, end, _ := sel.dateRange()
+			for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+				prefixes = append(prefixes, day.Format("2006/01/02/"))
+			}
+		}
+
+		for _, prefix := range prefixes {
+			listed, err := s3Service.ListAll(ctx, prefix)
+			if err != nil {
+				return nil, fmt.Errorf("listing %s: %w", prefix, err)
+			}
+			objects = append(objects, listed...)
+		}
+	}
+
+	p := &Plan{
+		Action:  req.Action,
+		Bucket:  s3Service.BucketName(),
+		Items:   make([]Item, 0, len(objects)),
+		Missing: missing,
+	}
+	for _, object := range objects {
+		item := Item{Key: object.Key, Size: object.Size}
+		if req.trashes() {
+			item.DestBucket = p.Bucket
+			item.DestKey = trashPrefix + object.Key
+		}
+		if req.Destination != nil {
+			item.DestBucket = req.Destination.Bucket
+			if item.DestBucket == "" {
+				item.DestBucket = p.Bucket
+			}
+			// A prefix selection is moved as a folder: keys keep their path
+			// below the selected prefix
+			item.DestKey = req.Destination.Prefix + strings.TrimPrefix(object.Key, sel.Prefix)
+			if item.DestBucket == p.Bucket && item.DestKey == item.Key {
+				return nil, fmt.Errorf("destination of %s is the object itself", item.Key)
+			}
+		}
+		p.Items = append(p.Items, item)
+		p.TotalBytes += object.Size
+	}
+	p.Count = len(p.Items)
+	return p, nil
+}
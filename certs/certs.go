@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"camera-viewer/config"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// reloadInterval is how often certificate files are checked for changes
+const reloadInterval = time.Minute
+
+// Manager provides the server's TLS certificates, either from files that
+// are reloaded when they change or from an ACME CA such as Let's Encrypt.
+// The zero Manager, from a config without certificates, serves plain HTTP.
+type Manager struct {
+	cfg  config.TLSConfig
+	acme *autocert.Manager
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func New(cfg config.TLSConfig) (*Manager, error) {
+	m := &Manager{cfg: cfg}
+	switch {
+	case cfg.CertFile != "":
+		if err := m.reload(); err != nil {
+			return nil, err
+		}
+	case len(cfg.ACMEDomains) > 0:
+		if err := os.MkdirAll(cfg.ACMECacheDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create ACME cache directory: %w", err)
+		}
+		m.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectoryURL != "" {
+			m.acme.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+		}
+	}
+	return m, nil
+}
+
+// Enabled reports whether the server speaks TLS
+func (m *Manager) Enabled() bool {
+	return m.cert != nil || m.acme != nil
+}
+
+// TLSConfig is the server's TLS configuration, picking up reloaded and
+// renewed certificates on each handshake
+func (m *Manager) TLSConfig() *tls.Config {
+	if m.acme != nil {
+		cfg := m.acme.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+		return cfg
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			m.mu.RLock()
+			defer m.mu.RUnlock()
+			return m.cert, nil
+		},
+	}
+}
+
+// Start reloads certificate files every minute when they change, until ctx
+// is done. ACME certificates are renewed by the ACME client itself.
+func (m *Manager) Start(ctx context.Context) {
+	if m.cert == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.reload(); err != nil {
+					slog.Warn("Keeping the current certificate", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// reload loads the certificate files if they changed since the last load
+func (m *Manager) reload() error {
+	modified, err := latestModTime(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	current := m.cert != nil && modified.Equal(m.modified)
+	m.mu.RUnlock()
+	if current {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse TLS certificate: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	reloaded := m.cert != nil
+	m.cert = &cert
+	m.modified = modified
+	m.mu.Unlock()
+	if reloaded {
+		slog.Info("Reloaded TLS certificate", "domains", strings.Join(cert.Leaf.DNSNames, ","), "valid_until", cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, fmt.Errorf("failed to read TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Check fails when the certificate loaded from files has expired. It is a
+// readiness check; ACME certificates are obtained on the first handshake.
+func (m *Manager) Check(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert != nil && time.Now().After(m.cert.Leaf.NotAfter) {
+		return fmt.Errorf("TLS certificate expired at %s", m.cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// HSTS adds the Strict-Transport-Security header to responses sent over
+// TLS, when HSTS_MAX_AGE is set
+func (m *Manager) HSTS(next http.Handler) http.Handler {
+	if m.cfg.HSTSMaxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int64(m.cfg.HSTSMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RedirectHandler answers plain HTTP requests by redirecting them to the
+// same URL on httpsPort. With ACME it also answers HTTP-01 challenges.
+func (m *Manager) RedirectHandler(httpsPort string) http.Handler {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+	if m.acme != nil {
+		return m.acme.HTTPHandler(redirect)
+	}
+	return redirect
+}
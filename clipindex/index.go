@@ -0,0 +1,776 @@
+package clipindex
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dayPrefix matches the folder of one day of clips
+var dayPrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2}/$`)
+
+// maxParams is how many keys are looked up per statement, as SQLite limits
+// the number of parameters in one
+const maxParams = 500
+
+// schemaVersion is stored in the database's user_version. The index only
+// holds data read from sidecars, so an index with another version is dropped
+// and rebuilt rather than migrated.
+const schemaVersion = 3
+
+// Times are stored as Unix milliseconds, except the modification times
+// used to detect changes, which are Unix nanoseconds. A clip without a
+// sidecar has a sidecar_modified of 0.
+const schemaSQL = `
+CREATE TABLE clips (
+	key TEXT PRIMARY KEY,
+	day TEXT NOT NULL,
+	camera TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	storage_class TEXT NOT NULL,
+	start_time INTEGER NOT NULL,
+	end_time INTEGER NOT NULL,
+	last_modified INTEGER NOT NULL,
+	sidecar_modified INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_clips_day ON clips(day);
+CREATE INDEX idx_clips_start ON clips(start_time);
+CREATE TABLE days (
+	prefix TEXT PRIMARY KEY,
+	synced_at INTEGER NOT NULL
+);
+CREATE TABLE annotations (
+	clip_key TEXT PRIMARY KEY,
+	note TEXT NOT NULL
+);
+CREATE TABLE clip_tags (
+	clip_key TEXT NOT NULL,
+	tag TEXT NOT NULL
+);
+CREATE INDEX idx_clip_tags_key ON clip_tags(clip_key);
+CREATE TABLE clip_zones (
+	clip_key TEXT NOT NULL REFERENCES clips(key) ON DELETE CASCADE,
+	zone TEXT NOT NULL
+);
+CREATE INDEX idx_clip_zones_key ON clip_zones(clip_key);
+CREATE TABLE detections (
+	clip_key TEXT NOT NULL REFERENCES clips(key) ON DELETE CASCADE,
+	label TEXT NOT NULL,
+	confidence REAL NOT NULL,
+	zone TEXT NOT NULL DEFAULT '',
+	offset_seconds REAL NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_detections_key ON detections(clip_key);
+CREATE INDEX idx_detections_label ON detections(label);
+`
+
+// Index keeps every clip, with the motion events read from the JSON sidecars
+// the cameras upload next to them, in SQLite for search. Recent days are
+// ingested periodically, older days once by a backfill, and any day again
+// when it is listed or the server changes a clip in it.
+type Index struct {
+	cfg       config.IndexConfig
+	s3Service *services.S3Service
+	db        *sql.DB
+
+	// ctx bounds background ingestion, which stops once it is done
+	ctx context.Context
+	// wg tracks the ingestion loop and the days ingested in the background
+	wg sync.WaitGroup
+
+	// syncMu serializes ingestion so a day is not ingested twice at once
+	syncMu sync.Mutex
+
+	mu sync.Mutex
+	// synced records when each day prefix was last ingested
+	synced map[string]time.Time
+	// refreshing holds the days being ingested in the background, true
+	// for those to be ingested again once done
+	refreshing map[string]bool
+
+	onAdded []func(ctx context.Context, keys []string)
+}
+
+func Open(cfg config.IndexConfig, s3Service *services.S3Service) (*Index, error) {
+	db, err := sql.Open("sqlite3", cfg.DBPath+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	if err := initSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize clip index: %w", err)
+	}
+
+	return &Index{
+		cfg:        cfg,
+		s3Service:  s3Service,
+		db:         db,
+		ctx:        context.Background(),
+		synced:     make(map[string]time.Time),
+		refreshing: make(map[string]bool),
+	}, nil
+}
+
+func initSchema(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version == schemaVersion {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if version != 0 {
+		slog.Info("Rebuilding clip index", "schema_version", version, "want", schemaVersion)
+	}
+	for _, table := range []string{"detections", "clip_zones", "clips", "days", "annotations", "clip_tags"} {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(schemaSQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// OnAdded registers fn to be called with the keys of the clips each sync adds
+// to the index, which after a rebuild is every clip. Register before Start.
+func (x *Index) OnAdded(fn func(ctx context.Context, keys []string)) {
+	x.onAdded = append(x.onAdded, fn)
+}
+
+func (x *Index) Close() error {
+	return x.db.Close()
+}
+
+// Ping checks that the index database can be read
+func (x *Index) Ping(ctx context.Context) error {
+	var count int
+	return x.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM days").Scan(&count)
+}
+
+// Start ingests the last INDEX_LOOKBACK_DAYS days every INDEX_INTERVAL until
+// ctx is done. The first time round it also backfills older days, retrying
+// on the next round if that fails.
+func (x *Index) Start(ctx context.Context) {
+	x.mu.Lock()
+	x.ctx = ctx
+	x.mu.Unlock()
+
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		ticker := time.NewTicker(x.cfg.Interval)
+		defer ticker.Stop()
+
+		backfilled := !x.cfg.Backfill
+		for {
+			now := time.Now()
+			for i := 0; i <= x.cfg.LookbackDays && ctx.Err() == nil; i++ {
+				if err := x.SyncDay(ctx, now.AddDate(0, 0, -i)); err != nil {
+					slog.Warn("Failed to index day", "day", now.AddDate(0, 0, -i).Format("2006-01-02"), "error", err)
+				}
+			}
+			if !backfilled {
+				if err := x.backfill(ctx); err != nil {
+					slog.Error("Failed to backfill clip index", "error", err)
+				} else {
+					backfilled = true
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until background ingestion has stopped, after the context
+// passed to Start is done
+func (x *Index) Wait() {
+	x.wg.Wait()
+}
+
+// backfill ingests every day in the bucket that has never been ingested.
+// Past days rarely change, so they are not ingested again unless listed.
+func (x *Index) backfill(ctx context.Context) error {
+	done := make(map[string]bool)
+	rows, err := x.db.QueryContext(ctx, "SELECT prefix FROM days")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var prefix string
+		if err := rows.Scan(&prefix); err != nil {
+			rows.Close()
+			return err
+		}
+		done[prefix] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	days, err := x.dayPrefixes(ctx)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, prefix := range days {
+		if done[prefix] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		objects, err := x.s3Service.ListAll(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		if err := x.Sync(ctx, prefix, objects); err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		slog.Info("Backfilled the clip index", "days", count)
+	}
+	return nil
+}
+
+// dayPrefixes walks the year/month/day folders of the bucket
+func (x *Index) dayPrefixes(ctx context.Context) ([]string, error) {
+	var days []string
+	years, err := x.s3Service.ListPrefixes(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, year := range years {
+		if !dayPrefix.MatchString(year + "01/01/") {
+			continue
+		}
+		months, err := x.s3Service.ListPrefixes(ctx, year)
+		if err != nil {
+			return nil, err
+		}
+		for _, month := range months {
+			prefixes, err := x.s3Service.ListPrefixes(ctx, month)
+			if err != nil {
+				return nil, err
+			}
+			for _, day := range prefixes {
+				if dayPrefix.MatchString(day) {
+					days = append(days, day)
+				}
+			}
+		}
+	}
+	return days, nil
+}
+
+// SyncDay lists a day and ingests its sidecars
+func (x *Index) SyncDay(ctx context.Context, day time.Time) error {
+	prefix := day.Format("2006/01/02/")
+	objects, err := x.s3Service.ListAll(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return x.Sync(ctx, prefix, objects)
+}
+
+// EnsureDays ingests the days from start to end that have not been ingested
+// within the last INDEX_INTERVAL
+func (x *Index) EnsureDays(ctx context.Context, start, end time.Time) error {
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	for day := first; !day.After(end); day = day.AddDate(0, 0, 1) {
+		x.mu.Lock()
+		last, ok := x.synced[day.Format("2006/01/02/")]
+		x.mu.Unlock()
+		if ok && time.Since(last) < x.cfg.Interval {
+			continue
+		}
+		if err := x.SyncDay(ctx, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh ingests objects, the listing of the day prefix, in the background
+// like Sync, and calls changed if that changed the index
+func (x *Index) Refresh(prefix string, objects []services.Video, changed func()) {
+	x.ingest(prefix, objects, changed)
+}
+
+// Forget drops a clip that was deleted or overwritten from the index, and
+// ingests its day again in the background, so search neither returns a
+// deleted clip nor misses one written since. Register it with
+// S3Service.OnChange.
+func (x *Index) Forget(key string) {
+	if len(key) < 11 || !dayPrefix.MatchString(key[:11]) {
+		return
+	}
+	if strings.HasSuffix(key, ".mp4") {
+		if _, err := x.db.Exec("DELETE FROM clips WHERE key = ?", key); err != nil {
+			slog.Warn("Failed to drop clip from the index", "key", key, "error", err)
+		}
+	}
+	x.ingest(key[:11], nil, nil)
+}
+
+// ingest ingests a day in the background from objects, or a new listing if
+// nil, and calls changed, if set, when that changes the index. A day asked
+// for while it is being ingested is listed and ingested once more after, so
+// changes made meanwhile are not missed.
+func (x *Index) ingest(prefix string, objects []services.Video, changed func()) {
+	x.mu.Lock()
+	if _, ok := x.refreshing[prefix]; ok {
+		x.refreshing[prefix] = true
+		x.mu.Unlock()
+		return
+	}
+	x.refreshing[prefix] = false
+	ctx := x.ctx
+	x.mu.Unlock()
+
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		for {
+			var err error
+			if objects == nil {
+				objects, err = x.s3Service.ListAll(ctx, prefix)
+			}
+			var updated bool
+			if err == nil {
+				updated, err = x.sync(ctx, prefix, objects)
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to index day", "day", strings.TrimSuffix(prefix, "/"), "error", err)
+			} else if updated && changed != nil {
+				changed()
+			}
+
+			x.mu.Lock()
+			if !x.refreshing[prefix] || ctx.Err() != nil {
+				delete(x.refreshing, prefix)
+				x.mu.Unlock()
+				return
+			}
+			x.refreshing[prefix] = false
+			x.mu.Unlock()
+			objects = nil
+		}
+	}()
+}
+
+// Sync indexes the clips among objects, the listing of the day prefix, and
+// ingests their sidecars. Sidecars are only downloaded when they are new or
+// changed, and clips that are gone are dropped from the index.
+func (x *Index) Sync(ctx context.Context, prefix string, objects []services.Video) error {
+	_, err := x.sync(ctx, prefix, objects)
+	return err
+}
+
+// sync is Sync, reporting whether the index changed
+func (x *Index) sync(ctx context.Context, prefix string, objects []services.Video) (bool, error) {
+	x.syncMu.Lock()
+	defer x.syncMu.Unlock()
+
+	sidecars := make(map[string]services.Video)
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, ".json") {
+			sidecars[object.Key] = object
+		}
+	}
+
+	indexed := make(map[string]int64)
+	rows, err := x.db.QueryContext(ctx, "SELECT key, sidecar_modified FROM clips WHERE day = ?", prefix)
+	if err != nil {
+		return false, err
+	}
+	for rows.Next() {
+		var key string
+		var modified int64
+		if err := rows.Scan(&key, &modified); err != nil {
+			rows.Close()
+			return false, err
+		}
+		indexed[key] = modified
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	// Sidecars are downloaded before the transaction so it is not held
+	// open across S3 requests
+	var clips []services.Video
+	var added []string
+	changed := make(map[string]*sidecarState)
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, ".mp4") {
+			continue
+		}
+		clips = append(clips, object)
+		if _, ok := indexed[object.Key]; !ok {
+			added = append(added, object.Key)
+		}
+
+		var modified int64
+		sidecar, ok := sidecars[SidecarKey(object.Key)]
+		if ok {
+			modified = sidecar.LastModified.UnixNano()
+		}
+		if previous, ok := indexed[object.Key]; ok && previous == modified {
+			continue
+		}
+
+		state := &sidecarState{modified: modified}
+		if modified != 0 {
+			if state.events, state.err, err = x.readSidecar(ctx, sidecar.Key); err != nil {
+				return false, err
+			}
+		}
+		changed[object.Key] = state
+	}
+
+	tx, err := x.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	present := make(map[string]bool)
+	for _, object := range clips {
+		present[object.Key] = true
+		if err := upsertClip(tx, prefix, object); err != nil {
+			return false, err
+		}
+		if state, ok := changed[object.Key]; ok {
+			if err := replaceEvents(tx, object.Key, state); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	removed := 0
+	for key := range indexed {
+		if present[key] {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM clips WHERE key = ?", key); err != nil {
+			return false, err
+		}
+		removed++
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO days (prefix, synced_at) VALUES (?, ?) ON CONFLICT(prefix) DO UPDATE SET synced_at = excluded.synced_at",
+		prefix, time.Now().Unix(),
+	); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	x.mu.Lock()
+	x.synced[prefix] = time.Now()
+	x.mu.Unlock()
+	if len(changed) > 0 || removed > 0 {
+		slog.InfoContext(ctx, "Indexed day", "day", strings.TrimSuffix(prefix, "/"), "changed", len(changed), "removed", removed)
+	}
+	if len(added) > 0 {
+		for _, fn := range x.onAdded {
+			fn(ctx, added)
+		}
+	}
+	return len(changed) > 0 || removed > 0, nil
+}
+
+// sidecarState is the outcome of reading a clip's sidecar. A clip without
+// a sidecar has a zero modified time.
+type sidecarState struct {
+	modified int64
+	events   *Events
+	// err is why the sidecar could not be read; it is recorded so the
+	// sidecar is not downloaded again until it changes
+	err string
+}
+
+// readSidecar downloads and parses a sidecar. Only S3 failures are returned
+// as errors; an unreadable sidecar is reported through problem.
+func (x *Index) readSidecar(ctx context.Context, key string) (events *Events, problem string, err error) {
+	body, err := x.s3Service.DownloadObject(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxSidecarBytes+1))
+	body.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	if len(data) > maxSidecarBytes {
+		err = fmt.Errorf("sidecar is larger than %d bytes", maxSidecarBytes)
+	} else {
+		events, err = parseSidecar(data)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping sidecar", "key", key, "error", err)
+		return nil, err.Error(), nil
+	}
+	return events, "", nil
+}
+
+func upsertClip(tx *sql.Tx, day string, object services.Video) error {
+	clip := services.NewClip(object, time.Local)
+	_, err := tx.Exec(`
+		INSERT INTO clips (key, day, camera, filename, size, storage_class, start_time, end_time, last_modified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			size = excluded.size,
+			storage_class = excluded.storage_class,
+			start_time = excluded.start_time,
+			end_time = excluded.end_time,
+			last_modified = excluded.last_modified`,
+		clip.Key, day, clip.Camera, clip.Filename, clip.Size, clip.StorageClass,
+		clip.Start.UnixMilli(), clip.End.UnixMilli(), object.LastModified.UnixNano(),
+	)
+	return err
+}
+
+// replaceEvents stores the events read from a clip's sidecar
+func replaceEvents(tx *sql.Tx, clipKey string, state *sidecarState) error {
+	if _, err := tx.Exec("UPDATE clips SET sidecar_modified = ?, error = ? WHERE key = ?", state.modified, state.err, clipKey); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM clip_zones WHERE clip_key = ?", clipKey); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM detections WHERE clip_key = ?", clipKey); err != nil {
+		return err
+	}
+	if state.events == nil {
+		return nil
+	}
+
+	for _, zone := range state.events.Zones {
+		if _, err := tx.Exec("INSERT INTO clip_zones (clip_key, zone) VALUES (?, ?)", clipKey, zone); err != nil {
+			return err
+		}
+	}
+	for _, d := range state.events.Objects {
+		if _, err := tx.Exec(
+			"INSERT INTO detections (clip_key, label, confidence, zone, offset_seconds) VALUES (?, ?, ?, ?, ?)",
+			clipKey, d.Label, d.Confidence, d.Zone, d.Offset,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Annotation is the copy of a clip's note and tags held for search. The
+// annotations package keeps the originals.
+type Annotation struct {
+	Note string   `json:"note"`
+	Tags []string `json:"tags"`
+}
+
+// SetAnnotation replaces the indexed note and tags of a clip; an empty note
+// and no tags remove them. Annotations are kept for keys that are not
+// indexed, since a clip may be annotated before it is ingested.
+func (x *Index) SetAnnotation(ctx context.Context, key, note string, tags []string) error {
+	tx, err := x.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM annotations WHERE clip_key = ?", key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM clip_tags WHERE clip_key = ?", key); err != nil {
+		return err
+	}
+	if note != "" || len(tags) > 0 {
+		if _, err := tx.Exec("INSERT INTO annotations (clip_key, note) VALUES (?, ?)", key, note); err != nil {
+			return err
+		}
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO clip_tags (clip_key, tag) VALUES (?, ?)", key, tag); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Annotations returns the indexed annotations of the given clips that have
+// one
+func (x *Index) Annotations(ctx context.Context, keys []string) (map[string]*Annotation, error) {
+	result := make(map[string]*Annotation)
+	for start := 0; start < len(keys); start += maxParams {
+		end := start + maxParams
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := x.loadAnnotations(ctx, keys[start:end], result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (x *Index) loadAnnotations(ctx context.Context, keys []string, result map[string]*Annotation) error {
+	placeholders, args := inList(keys)
+
+	rows, err := x.db.QueryContext(ctx, "SELECT clip_key, note FROM annotations WHERE clip_key IN ("+placeholders+")", args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		a := &Annotation{Tags: []string{}}
+		var key string
+		if err := rows.Scan(&key, &a.Note); err != nil {
+			rows.Close()
+			return err
+		}
+		result[key] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = x.db.QueryContext(ctx, "SELECT clip_key, tag FROM clip_tags WHERE clip_key IN ("+placeholders+") ORDER BY tag", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, tag string
+		if err := rows.Scan(&key, &tag); err != nil {
+			return err
+		}
+		if a, ok := result[key]; ok {
+			a.Tags = append(a.Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// inList returns the placeholders and arguments of an IN (...) clause
+func inList(keys []string) (string, []interface{}) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	return placeholders, args
+}
+
+// Events returns the events of the given clips that have a readable sidecar
+func (x *Index) Events(ctx context.Context, keys []string) (map[string]*Events, error) {
+	result := make(map[string]*Events)
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	for start := 0; start < len(keys); start += maxParams {
+		end := start + maxParams
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := x.loadEvents(ctx, keys[start:end], result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (x *Index) loadEvents(ctx context.Context, keys []string, result map[string]*Events) error {
+	placeholders, args := inList(keys)
+
+	rows, err := x.db.QueryContext(ctx, "SELECT key FROM clips WHERE sidecar_modified != 0 AND error = '' AND key IN ("+placeholders+")", args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		result[key] = &Events{Zones: []string{}, Objects: []Detection{}}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = x.db.QueryContext(ctx, "SELECT clip_key, zone FROM clip_zones WHERE clip_key IN ("+placeholders+") ORDER BY rowid", args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key, zone string
+		if err := rows.Scan(&key, &zone); err != nil {
+			rows.Close()
+			return err
+		}
+		if events, ok := result[key]; ok {
+			events.Zones = append(events.Zones, zone)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = x.db.QueryContext(ctx, "SELECT clip_key, label, confidence, zone, offset_seconds FROM detections WHERE clip_key IN ("+placeholders+") ORDER BY offset_seconds", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var d Detection
+		if err := rows.Scan(&key, &d.Label, &d.Confidence, &d.Zone, &d.Offset); err != nil {
+			return err
+		}
+		if events, ok := result[key]; ok {
+			events.Objects = append(events.Objects, d)
+		}
+	}
+	return rows.Err()
+}
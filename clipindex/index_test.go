@@ -0,0 +1,73 @@
+package clipindex
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services/s3test"
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeletedClipLeavesSearch(t *testing.T) {
+	server := s3test.NewServer(t, "cams")
+	s3Service := s3test.NewService(t, server, config.StorageConfig{
+		Region:         "us-east-1",
+		ForcePathStyle: true,
+	})
+
+	cfg := config.Default().Index
+	cfg.DBPath = filepath.Join(t.TempDir(), "clip-index.db")
+	x, err := Open(cfg, s3Service)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { x.Close() })
+	s3Service.OnChange(x.Forget)
+
+	ctx := context.Background()
+	objects := map[string]string{
+		"2024/01/15/front_door_20240115_120000.mp4":  "first clip",
+		"2024/01/15/front_door_20240115_120000.json": `{"objects":[{"label":"person","confidence":0.9}]}`,
+		"2024/01/15/garage_20240115_130000.mp4":      "second clip",
+		"2024/01/15/garage_20240115_130000.json":     `{"objects":[{"label":"person","confidence":0.8}]}`,
+	}
+	for key, body := range objects {
+		if err := s3Service.UploadObject(ctx, key, strings.NewReader(body)); err != nil {
+			t.Fatalf("UploadObject %s: %v", key, err)
+		}
+	}
+	if err := x.SyncDay(ctx, time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local)); err != nil {
+		t.Fatalf("SyncDay: %v", err)
+	}
+
+	search := func() []string {
+		t.Helper()
+		query, err := ParseQuery(url.Values{"label": {"person"}, "sort": {"oldest"}}, time.Local)
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		results, err := x.Search(ctx, query)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		var keys []string
+		for _, r := range results.Results {
+			keys = append(keys, r.Key)
+		}
+		return keys
+	}
+
+	if keys := search(); len(keys) != 2 {
+		t.Fatalf("Search before delete = %v, want both clips", keys)
+	}
+
+	if err := s3Service.DeleteObject(ctx, "2024/01/15/front_door_20240115_120000.mp4"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	if keys := search(); len(keys) != 1 || keys[0] != "2024/01/15/garage_20240115_130000.mp4" {
+		t.Errorf("Search after delete = %v, want only the garage clip", keys)
+	}
+}
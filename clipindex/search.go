@@ -0,0 +1,352 @@
+package clipindex
+
+import (
+	"camera-viewer/services"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// sortOrders maps the sort parameter of a search to its ORDER BY clause
+var sortOrders = map[string]string{
+	"newest":   "c.start_time DESC",
+	"oldest":   "c.start_time ASC",
+	"largest":  "c.size DESC, c.start_time DESC",
+	"smallest": "c.size ASC, c.start_time DESC",
+	"longest":  "(c.end_time - c.start_time) DESC, c.start_time DESC",
+	"shortest": "(c.end_time - c.start_time) ASC, c.start_time DESC",
+}
+
+// Query is a clip search. Zero fields do not filter.
+type Query struct {
+	// Start and End select clips recorded at least partly in the range
+	Start time.Time
+	End   time.Time
+	// Camera and Tag match exactly; Text matches filenames, labels, zones,
+	// notes and tags containing every word of it
+	Camera       string
+	Tag          string
+	Text         string
+	StorageClass string
+	MinSize      int64
+	MaxSize      int64
+	MinDuration  time.Duration
+	MaxDuration  time.Duration
+	// Events filters on detections and zones like the listing APIs
+	Events Filter
+
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// Result is one clip found by a search
+type Result struct {
+	services.Clip
+	// Duration is in seconds
+	Duration float64  `json:"duration"`
+	Events   *Events  `json:"events,omitempty"`
+	Note     string   `json:"note,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// SearchResults is a page of results, with facet counts over all matches
+type SearchResults struct {
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	Sort    string   `json:"sort"`
+	Results []Result `json:"results"`
+	Facets  Facets   `json:"facets"`
+}
+
+// Facets count the matching clips by each value of a field
+type Facets struct {
+	Cameras        map[string]int `json:"cameras"`
+	StorageClasses map[string]int `json:"storage_classes"`
+	Days           map[string]int `json:"days"`
+	Labels         map[string]int `json:"labels"`
+	Zones          map[string]int `json:"zones"`
+	Tags           map[string]int `json:"tags"`
+}
+
+// ParseQuery reads a search from query parameters. start and end are
+// RFC3339 times or YYYY-MM-DD dates, an end date including the whole day;
+// dates and times without a zone are in loc.
+func ParseQuery(query url.Values, loc *time.Location) (Query, error) {
+	q := Query{
+		Camera:       strings.TrimSpace(query.Get("camera")),
+		Tag:          strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Text:         strings.TrimSpace(query.Get("q")),
+		StorageClass: strings.ToUpper(strings.TrimSpace(query.Get("storage_class"))),
+		Sort:         query.Get("sort"),
+		Limit:        defaultSearchLimit,
+	}
+
+	var err error
+	if q.Events, err = ParseFilter(query); err != nil {
+		return q, err
+	}
+
+	if v := query.Get("start"); v != "" {
+		if q.Start, err = parseSearchTime(v, loc, false); err != nil {
+			return q, fmt.Errorf("invalid start (use YYYY-MM-DD or RFC3339)")
+		}
+	}
+	if v := query.Get("end"); v != "" {
+		if q.End, err = parseSearchTime(v, loc, true); err != nil {
+			return q, fmt.Errorf("invalid end (use YYYY-MM-DD or RFC3339)")
+		}
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && !q.End.After(q.Start) {
+		return q, fmt.Errorf("end must be after start")
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"min_size", &q.MinSize}, {"max_size", &q.MaxSize}} {
+		if v := query.Get(p.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s (use a number of bytes)", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"min_duration", &q.MinDuration}, {"max_duration", &q.MaxDuration}} {
+		if v := query.Get(p.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return q, fmt.Errorf("invalid %s (use a duration such as 30s)", p.name)
+			}
+			*p.dst = d
+		}
+	}
+
+	if q.Sort == "" {
+		q.Sort = "newest"
+	}
+	if _, ok := sortOrders[q.Sort]; !ok {
+		return q, fmt.Errorf("invalid sort (use newest, oldest, largest, smallest, longest or shortest)")
+	}
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxSearchLimit {
+			return q, fmt.Errorf("invalid limit (use 1 to %d)", maxSearchLimit)
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("invalid offset")
+		}
+	}
+	return q, nil
+}
+
+func parseSearchTime(v string, loc *time.Location, end bool) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		if end {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// where builds the WHERE clause selecting the clips matching q, for the
+// clips table aliased as c
+func (q Query) where() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	var args []interface{}
+
+	if !q.Start.IsZero() {
+		conds = append(conds, "c.end_time > ?")
+		args = append(args, q.Start.UnixMilli())
+	}
+	if !q.End.IsZero() {
+		conds = append(conds, "c.start_time < ?")
+		args = append(args, q.End.UnixMilli())
+	}
+	if q.Camera != "" {
+		conds = append(conds, "c.camera = ?")
+		args = append(args, q.Camera)
+	}
+	if q.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM clip_tags t WHERE t.clip_key = c.key AND t.tag = ?)")
+		args = append(args, q.Tag)
+	}
+	if q.StorageClass != "" {
+		conds = append(conds, "c.storage_class = ?")
+		args = append(args, q.StorageClass)
+	}
+	if q.MinSize > 0 {
+		conds = append(conds, "c.size >= ?")
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		conds = append(conds, "c.size <= ?")
+		args = append(args, q.MaxSize)
+	}
+	if q.MinDuration > 0 {
+		conds = append(conds, "c.end_time - c.start_time >= ?")
+		args = append(args, q.MinDuration.Milliseconds())
+	}
+	if q.MaxDuration > 0 {
+		conds = append(conds, "c.end_time - c.start_time <= ?")
+		args = append(args, q.MaxDuration.Milliseconds())
+	}
+
+	for _, word := range strings.Fields(q.Text) {
+		pattern := "%" + escapeLike(word) + "%"
+		conds = append(conds, `(c.filename LIKE ? ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM detections d WHERE d.clip_key = c.key AND d.label LIKE ? ESCAPE '\')
+			OR EXISTS (SELECT 1 FROM clip_zones z WHERE z.clip_key = c.key AND z.zone LIKE ? ESCAPE '\')
+			OR EXISTS (SELECT 1 FROM annotations a WHERE a.clip_key = c.key AND a.note LIKE ? ESCAPE '\')
+			OR EXISTS (SELECT 1 FROM clip_tags t WHERE t.clip_key = c.key AND t.tag LIKE ? ESCAPE '\'))`)
+		args = append(args, pattern, pattern, pattern, pattern, pattern)
+	}
+
+	// The same rules as Filter.Match
+	f := q.Events
+	switch {
+	case f.Label != "" || f.MinConfidence > 0:
+		cond := "EXISTS (SELECT 1 FROM detections d WHERE d.clip_key = c.key AND d.confidence >= ?"
+		args = append(args, f.MinConfidence)
+		if f.Label != "" {
+			cond += " AND d.label = ? COLLATE NOCASE"
+			args = append(args, f.Label)
+		}
+		if f.Zone != "" {
+			cond += ` AND (d.zone = ? COLLATE NOCASE OR (d.zone = '' AND EXISTS (
+				SELECT 1 FROM clip_zones z WHERE z.clip_key = c.key AND z.zone = ? COLLATE NOCASE)))`
+			args = append(args, f.Zone, f.Zone)
+		}
+		conds = append(conds, cond+")")
+	case f.Zone != "":
+		conds = append(conds, `(EXISTS (SELECT 1 FROM clip_zones z WHERE z.clip_key = c.key AND z.zone = ? COLLATE NOCASE)
+			OR EXISTS (SELECT 1 FROM detections d WHERE d.clip_key = c.key AND d.zone = ? COLLATE NOCASE))`)
+		args = append(args, f.Zone, f.Zone)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Search finds indexed clips. Only ingested days are searched; see Start.
+func (x *Index) Search(ctx context.Context, q Query) (*SearchResults, error) {
+	where, args := q.where()
+
+	results := &SearchResults{
+		Offset:  q.Offset,
+		Limit:   q.Limit,
+		Sort:    q.Sort,
+		Results: []Result{},
+	}
+	if err := x.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM clips c WHERE "+where, args...).Scan(&results.Total); err != nil {
+		return nil, fmt.Errorf("failed to count results: %w", err)
+	}
+
+	rows, err := x.db.QueryContext(ctx, `
+		SELECT c.key, c.filename, c.camera, c.size, c.storage_class, c.start_time, c.end_time
+		FROM clips c WHERE `+where+`
+		ORDER BY `+sortOrders[q.Sort]+`, c.key
+		LIMIT ? OFFSET ?`,
+		append(args, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search clips: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var r Result
+		var start, end int64
+		if err := rows.Scan(&r.Key, &r.Filename, &r.Camera, &r.Size, &r.StorageClass, &start, &end); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.Start = time.UnixMilli(start).In(time.Local)
+		r.End = time.UnixMilli(end).In(time.Local)
+		r.Duration = float64(end-start) / 1000
+		r.Playable = r.StorageClass != "GLACIER" && r.StorageClass != "DEEP_ARCHIVE"
+		results.Results = append(results.Results, r)
+		keys = append(keys, r.Key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	events, err := x.Events(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := x.Annotations(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results.Results {
+		r := &results.Results[i]
+		r.Events = events[r.Key]
+		if a := annotations[r.Key]; a != nil {
+			r.Note = a.Note
+			r.Tags = a.Tags
+		}
+	}
+
+	if results.Facets, err = x.facets(ctx, where, args); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (x *Index) facets(ctx context.Context, where string, args []interface{}) (Facets, error) {
+	facets := Facets{}
+	queries := []struct {
+		dst   *map[string]int
+		query string
+	}{
+		{&facets.Cameras, "SELECT c.camera, COUNT(*) FROM clips c WHERE " + where + " GROUP BY 1"},
+		{&facets.StorageClasses, "SELECT c.storage_class, COUNT(*) FROM clips c WHERE " + where + " GROUP BY 1"},
+		{&facets.Days, "SELECT replace(rtrim(c.day, '/'), '/', '-'), COUNT(*) FROM clips c WHERE " + where + " GROUP BY 1"},
+		{&facets.Labels, "SELECT lower(d.label), COUNT(DISTINCT c.key) FROM clips c JOIN detections d ON d.clip_key = c.key WHERE " + where + " GROUP BY 1"},
+		{&facets.Zones, "SELECT lower(z.zone), COUNT(DISTINCT c.key) FROM clips c JOIN clip_zones z ON z.clip_key = c.key WHERE " + where + " GROUP BY 1"},
+		{&facets.Tags, "SELECT t.tag, COUNT(DISTINCT c.key) FROM clips c JOIN clip_tags t ON t.clip_key = c.key WHERE " + where + " GROUP BY 1"},
+	}
+
+	for _, fq := range queries {
+		counts := make(map[string]int)
+		rows, err := x.db.QueryContext(ctx, fq.query, args...)
+		if err != nil {
+			return facets, fmt.Errorf("failed to count facets: %w", err)
+		}
+		for rows.Next() {
+			var value string
+			var count int
+			if err := rows.Scan(&value, &count); err != nil {
+				rows.Close()
+				return facets, err
+			}
+			counts[value] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return facets, err
+		}
+		*fq.dst = counts
+	}
+	return facets, nil
+}
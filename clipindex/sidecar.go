@@ -0,0 +1,143 @@
+package clipindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxSidecarBytes bounds the size of a sidecar file that is ingested
+const maxSidecarBytes = 1 << 20
+
+// Events is the motion metadata of one clip, as written by the camera in a
+// JSON sidecar next to it:
+//
+//	{
+//	  "zones": ["driveway"],
+//	  "objects": [{"label": "person", "confidence": 0.91, "zone": "driveway", "offset": 3.5}]
+//	}
+type Events struct {
+	// Zones are the motion zones that triggered the recording
+	Zones   []string    `json:"zones"`
+	Objects []Detection `json:"objects"`
+}
+
+// Detection is an object detected in a clip
+type Detection struct {
+	Label      string  `json:"label"`
+	Confidence float64 `json:"confidence"`
+	Zone       string  `json:"zone,omitempty"`
+	// Offset is the number of seconds into the clip of the detection
+	Offset float64 `json:"offset"`
+}
+
+// SidecarKey returns the key of the sidecar belonging to a clip
+func SidecarKey(clipKey string) string {
+	return strings.TrimSuffix(clipKey, ".mp4") + ".json"
+}
+
+func parseSidecar(data []byte) (*Events, error) {
+	var events Events
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("invalid sidecar: %w", err)
+	}
+	if events.Zones == nil {
+		events.Zones = []string{}
+	}
+	if events.Objects == nil {
+		events.Objects = []Detection{}
+	}
+	for i, d := range events.Objects {
+		if d.Label == "" {
+			return nil, fmt.Errorf("invalid sidecar: object %d has no label", i)
+		}
+		if d.Confidence < 0 || d.Confidence > 1 {
+			return nil, fmt.Errorf("invalid sidecar: object %d has confidence %g (use 0 to 1)", i, d.Confidence)
+		}
+	}
+	return &events, nil
+}
+
+// Filter selects clips by their events. The zero Filter matches every clip,
+// including clips without a sidecar.
+type Filter struct {
+	Label         string
+	Zone          string
+	MinConfidence float64
+}
+
+// ParseFilter reads the label, zone and min_confidence query parameters
+func ParseFilter(query url.Values) (Filter, error) {
+	f := Filter{
+		Label: strings.TrimSpace(query.Get("label")),
+		Zone:  strings.TrimSpace(query.Get("zone")),
+	}
+	if v := query.Get("min_confidence"); v != "" {
+		c, err := strconv.ParseFloat(v, 64)
+		if err != nil || c < 0 || c > 1 {
+			return f, fmt.Errorf("invalid min_confidence (use a number from 0 to 1)")
+		}
+		f.MinConfidence = c
+	}
+	return f, nil
+}
+
+// IsZero reports whether the filter matches every clip
+func (f Filter) IsZero() bool {
+	return f == Filter{}
+}
+
+// Match reports whether a clip with the given events, nil if it has no
+// sidecar, passes the filter. With a label or confidence, a single detection
+// must satisfy all of them; a detection without a zone of its own is in the
+// clip's motion zones.
+func (f Filter) Match(events *Events) bool {
+	if f.IsZero() {
+		return true
+	}
+	if events == nil {
+		return false
+	}
+
+	if f.Label == "" && f.MinConfidence == 0 {
+		if containsFold(events.Zones, f.Zone) {
+			return true
+		}
+		for _, d := range events.Objects {
+			if strings.EqualFold(d.Zone, f.Zone) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, d := range events.Objects {
+		if f.Label != "" && !strings.EqualFold(d.Label, f.Label) {
+			continue
+		}
+		if d.Confidence < f.MinConfidence {
+			continue
+		}
+		if f.Zone != "" {
+			if d.Zone != "" && !strings.EqualFold(d.Zone, f.Zone) {
+				continue
+			}
+			if d.Zone == "" && !containsFold(events.Zones, f.Zone) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,42 +1,395 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// DefaultFile is the configuration file read when CONFIG_FILE is not set,
+// if it exists
+const DefaultFile = "config.yaml"
+
+// Config is the configuration of the server and the discord notifier. It is
+// read from a YAML file, whose keys are given by the yaml tags, and each
+// setting can be overridden by the environment variable in its env tag.
+// Settings tagged secret are redacted when the configuration is printed.
 type Config struct {
-	AWSRegion          string
-	AWSAccessKeyID     string
-	AWSSecretAccessKey string
-	Port               string
-	AppEnv             string
-	BucketName         string
+	Server  ServerConfig  `yaml:"server"`
+	Auth    AuthConfig    `yaml:"auth"`
+	Storage StorageConfig `yaml:"storage"`
+	// Cameras holds per-camera settings by camera name, the first part of
+	// a clip's file name
+	Cameras map[string]CameraConfig `yaml:"cameras"`
+
+	Notifier NotifierConfig `yaml:"notifier"`
+	Export   ExportConfig   `yaml:"export"`
+	Trash    TrashConfig    `yaml:"trash"`
+	HLS      HLSConfig      `yaml:"hls"`
+	Index    IndexConfig    `yaml:"index"`
+
+	Annotations AnnotationsConfig `yaml:"annotations"`
+	Stats       StatsConfig       `yaml:"stats"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Health      HealthConfig      `yaml:"health"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+}
+
+// AuthConfig configures basic authentication. Without a username and
+// password every endpoint is open.
+type AuthConfig struct {
+	Username string `yaml:"username" env:"USERNAME"`
+	Password string `yaml:"password" env:"PASSWORD" secret:"true"`
+}
+
+// StorageConfig configures the S3 bucket holding the clips. Without keys the
+// SDK's default credential chain is used, such as an instance role.
+type StorageConfig struct {
+	Region          string `yaml:"region" env:"AWS_REGION"`
+	AccessKeyID     string `yaml:"access_key_id" env:"AWS_ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"AWS_SECRET_ACCESS_KEY" secret:"true"`
+	Bucket          string `yaml:"bucket" env:"BUCKET_NAME"`
+	// Endpoint is the URL of an S3-compatible service, such as MinIO or
+	// Cloudflare R2, used instead of AWS
+	Endpoint string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	// ForcePathStyle addresses the bucket in the path rather than the host
+	// name, which MinIO and most self-hosted services need
+	ForcePathStyle bool `yaml:"force_path_style" env:"S3_FORCE_PATH_STYLE"`
+	// DisableChecksums only sends and checks checksums where an operation
+	// requires them, for services that reject the SDK's default CRC headers
+	DisableChecksums bool `yaml:"disable_checksums" env:"S3_DISABLE_CHECKSUMS"`
+}
+
+// CameraConfig is the notification schedule of a camera, which replaces the
+// one for the same camera in NOTIFIER_SCHEDULE_FILE. The file's camera and
+// channel schedules use the same fields.
+type CameraConfig struct {
+	// QuietHours are local "HH:MM-HH:MM" ranges, which may wrap midnight
+	QuietHours []string `yaml:"quiet_hours,omitempty" json:"quiet_hours,omitempty"`
+	// Days lists the weekdays notifications are allowed on ("mon", "tue", ...)
+	Days []string `yaml:"days,omitempty" json:"days,omitempty"`
+	// WeekdaysOnly is shorthand for Days = mon..fri
+	WeekdaysOnly bool `yaml:"weekdays_only,omitempty" json:"weekdays_only,omitempty"`
+}
+
+// NotifierConfig configures the discord notifier, whether it runs as the
+// standalone cron binary or as a worker inside the server
+type NotifierConfig struct {
+	// Enabled starts the in-process worker in the camera-viewer server
+	Enabled  bool          `yaml:"enabled" env:"NOTIFIER_ENABLED"`
+	Interval time.Duration `yaml:"interval" env:"NOTIFIER_INTERVAL"`
+
+	DiscordWebhookURL string        `yaml:"discord_webhook_url" env:"DISCORD_WEBHOOK_URL" secret:"true"`
+	DBPath            string        `yaml:"db_path" env:"NOTIFIER_DB_PATH"`
+	CameraViewerURL   string        `yaml:"camera_viewer_url" env:"CAMERA_VIEWER_URL"`
+	Mode              string        `yaml:"mode" env:"NOTIFY_MODE"`
+	BatchWindow       time.Duration `yaml:"batch_window" env:"BATCH_WINDOW"`
+	MaxRetries        int           `yaml:"max_retries" env:"DISCORD_MAX_RETRIES"`
+	MaxAttempts       int           `yaml:"max_attempts" env:"NOTIFIER_MAX_ATTEMPTS"`
+	LookbackDays      int           `yaml:"lookback_days" env:"NOTIFIER_LOOKBACK_DAYS"`
+	RetentionDays     int           `yaml:"retention_days" env:"NOTIFIER_RETENTION_DAYS"`
+	ScheduleFile      string        `yaml:"schedule_file" env:"NOTIFIER_SCHEDULE_FILE"`
+	AwayFile          string        `yaml:"away_file" env:"NOTIFIER_AWAY_FILE"`
+}
+
+// ExportConfig configures export jobs, which package clips for download
+type ExportConfig struct {
+	// Dir holds the files of finished exports until they expire
+	Dir        string        `yaml:"dir" env:"EXPORT_DIR"`
+	FFmpegPath string        `yaml:"ffmpeg_path" env:"FFMPEG_PATH"`
+	Retention  time.Duration `yaml:"retention" env:"EXPORT_RETENTION"`
+	// MaxClips caps the number of clips in one export
+	MaxClips int `yaml:"max_clips" env:"EXPORT_MAX_CLIPS"`
+	// MaxBytes caps the disk space used in Dir by running and finished
+	// exports together
+	MaxBytes int64 `yaml:"max_bytes" env:"EXPORT_MAX_BYTES"`
+	// MaxZIPBytes caps the total size of clips in one streamed ZIP download
+	MaxZIPBytes int64 `yaml:"max_zip_bytes" env:"ZIP_MAX_BYTES"`
+}
+
+// TrashConfig configures soft delete
+type TrashConfig struct {
+	// Prefix is where deleted clips are kept, under their original key
+	Prefix string `yaml:"prefix" env:"TRASH_PREFIX"`
+	// Retention is how long deleted clips are kept before they are purged
+	Retention time.Duration `yaml:"retention" env:"TRASH_RETENTION"`
+}
+
+// HLSConfig configures transcoding clips into HLS renditions for adaptive
+// streaming
+type HLSConfig struct {
+	Enabled bool `yaml:"enabled" env:"HLS_ENABLED"`
+	// Dir caches transcoded renditions
+	Dir        string `yaml:"dir" env:"HLS_DIR"`
+	FFmpegPath string `yaml:"ffmpeg_path" env:"FFMPEG_PATH"`
+	// Renditions is a comma-separated list of height:bitrate pairs, for
+	// example "720:2800k,480:1200k"
+	Renditions string `yaml:"renditions" env:"HLS_RENDITIONS"`
+	// Retention is how long a transcoded clip is kept after it was last
+	// requested
+	Retention time.Duration `yaml:"retention" env:"HLS_RETENTION"`
+	// MaxJobs limits how many clips are transcoded at once
+	MaxJobs int `yaml:"max_jobs" env:"HLS_MAX_JOBS"`
+}
+
+// IndexConfig configures the clip index, which holds the motion events read
+// from the cameras' JSON sidecars
+type IndexConfig struct {
+	DBPath string `yaml:"db_path" env:"INDEX_DB_PATH"`
+	// Interval is how often recent days are ingested, and how long an
+	// ingested day is considered current
+	Interval time.Duration `yaml:"interval" env:"INDEX_INTERVAL"`
+	// LookbackDays is how many days before today are ingested in the
+	// background
+	LookbackDays int `yaml:"lookback_days" env:"INDEX_LOOKBACK_DAYS"`
+	// Backfill ingests all older days once at startup
+	Backfill bool `yaml:"backfill" env:"INDEX_BACKFILL"`
+}
+
+// AnnotationsConfig configures where the notes and tags users attach to
+// clips are kept
+type AnnotationsConfig struct {
+	// Store is "local" for a SQLite database or "s3" for S3 object tags
+	Store  string `yaml:"store" env:"ANNOTATIONS_STORE"`
+	DBPath string `yaml:"db_path" env:"ANNOTATIONS_DB_PATH"`
+}
+
+// StatsConfig configures the storage statistics of /stats
+type StatsConfig struct {
+	// Concurrency is how many days are listed at once
+	Concurrency int `yaml:"concurrency" env:"STATS_CONCURRENCY"`
+	// CacheTTL is how long the totals of a finished day are reused before
+	// it is listed again, to pick up lifecycle transitions and deletions
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"STATS_CACHE_TTL"`
+}
+
+// CacheConfig configures the cache of bucket listing responses
+type CacheConfig struct {
+	// TTL is how long a listing is served from the cache. Changes made
+	// through the server remove affected listings at once; uploads by the
+	// cameras show up once their listing expires.
+	TTL time.Duration `yaml:"ttl" env:"LISTING_CACHE_TTL"`
+	// File persists the cache across restarts when set
+	File       string `yaml:"file" env:"LISTING_CACHE_FILE"`
+	MaxEntries int    `yaml:"max_entries" env:"LISTING_CACHE_MAX_ENTRIES"`
+}
+
+// ServerConfig configures the HTTP server. Footage downloads lift the write
+// timeout, so it only bounds the other responses.
+type ServerConfig struct {
+	Port   string `yaml:"port" env:"PORT"`
+	AppEnv string `yaml:"app_env" env:"APP_ENV"`
+
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after a shutdown signal
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
 }
 
+// TLSConfig configures HTTPS. Certificates come from files or from an ACME
+// CA such as Let's Encrypt; with neither the server speaks plain HTTP.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files, reloaded when they change
+	CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE"`
+
+	ACMEDomains  []string `yaml:"acme_domains" env:"ACME_DOMAINS"`
+	ACMEEmail    string   `yaml:"acme_email" env:"ACME_EMAIL"`
+	ACMECacheDir string   `yaml:"acme_cache_dir" env:"ACME_CACHE_DIR"`
+	// ACMEDirectoryURL selects another CA, such as Let's Encrypt staging
+	ACMEDirectoryURL string `yaml:"acme_directory_url" env:"ACME_DIRECTORY_URL"`
+
+	// RedirectPort serves redirects to HTTPS and ACME HTTP-01 challenges
+	// when set
+	RedirectPort string `yaml:"redirect_port" env:"TLS_REDIRECT_PORT"`
+	// HSTSMaxAge sends Strict-Transport-Security over HTTPS when positive
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age" env:"HSTS_MAX_AGE"`
+}
+
+// LogConfig configures the server's logs
+type LogConfig struct {
+	// Level is debug, info, warn or error; debug adds every S3 call
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// Format is text or json
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// Access logs every request once it has been answered
+	Access bool `yaml:"access" env:"ACCESS_LOG"`
+}
+
+// TracingConfig configures OpenTelemetry tracing of requests and S3 calls
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled" env:"TRACING_ENABLED"`
+	// Endpoint is the OTLP/HTTP collector's base URL
+	Endpoint    string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+	// SampleRatio is the share of requests traced, from 0 to 1
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// HealthConfig configures the readiness checks
+type HealthConfig struct {
+	// Timeout bounds each dependency check
+	Timeout time.Duration `yaml:"timeout" env:"HEALTH_CHECK_TIMEOUT"`
+}
+
+// Default returns the configuration used for settings that neither the file
+// nor the environment set
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              "8080",
+			AppEnv:            "development",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      2 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   time.Minute,
+		},
+		Storage: StorageConfig{
+			Region: "us-east-1",
+		},
+		Notifier: NotifierConfig{
+			Interval:      time.Minute,
+			DBPath:        "./discord-notifier.db",
+			Mode:          "immediate",
+			BatchWindow:   10 * time.Minute,
+			MaxRetries:    3,
+			MaxAttempts:   10,
+			LookbackDays:  2,
+			RetentionDays: 30,
+		},
+		Export: ExportConfig{
+			Dir:         filepath.Join(os.TempDir(), "camera-viewer-exports"),
+			FFmpegPath:  "ffmpeg",
+			Retention:   24 * time.Hour,
+			MaxClips:    500,
+			MaxBytes:    20 << 30,
+			MaxZIPBytes: 2 << 30,
+		},
+		Trash: TrashConfig{
+			Prefix:    "trash/",
+			Retention: 30 * 24 * time.Hour,
+		},
+		HLS: HLSConfig{
+			Dir:        filepath.Join(os.TempDir(), "camera-viewer-hls"),
+			FFmpegPath: "ffmpeg",
+			Renditions: "1080:5000k,720:2800k,480:1200k",
+			Retention:  7 * 24 * time.Hour,
+			MaxJobs:    1,
+		},
+		Index: IndexConfig{
+			DBPath:       "./clip-index.db",
+			Interval:     5 * time.Minute,
+			LookbackDays: 1,
+			Backfill:     true,
+		},
+		Annotations: AnnotationsConfig{
+			Store:  "local",
+			DBPath: "./annotations.db",
+		},
+		Stats: StatsConfig{
+			Concurrency: 8,
+			CacheTTL:    24 * time.Hour,
+		},
+		Cache: CacheConfig{
+			TTL:        5 * time.Minute,
+			MaxEntries: 1000,
+		},
+		Health: HealthConfig{
+			Timeout: 3 * time.Second,
+		},
+		TLS: TLSConfig{
+			ACMECacheDir: "./acme-cache",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+			Access: true,
+		},
+		Tracing: TracingConfig{
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "camera-viewer",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load reads the configuration from the file in CONFIG_FILE, or from
+// config.yaml if it exists, and the environment, including a .env file
 func Load() (*Config, error) {
+	return LoadFile("")
+}
+
+// LoadFile reads the configuration from the YAML file at path, or like Load
+// when path is empty, then applies the environment and validates the result.
+// The error lists every invalid setting.
+func LoadFile(path string) (*Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Read is LoadFile without the validation, for tools that only use a few
+// settings, such as the notifier's database commands
+func Read(path string) (*Config, error) {
 	if err := godotenv.Load(); err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
 	}
 
-	cfg := &Config{
-		AWSRegion:          getEnv("AWS_REGION", "us-east-1"),
-		AWSAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
-		Port:               getEnv("PORT", "8080"),
-		AppEnv:             getEnv("APP_ENV", "development"),
-		BucketName:         os.Getenv("BUCKET_NAME"),
+	cfg := Default()
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			path = DefaultFile
+		}
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
 	}
 
+	var errs []error
+	applyEnv(cfg, &errs)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// readFile sets the settings present in a YAML file. Unknown keys are errors,
+// so a misspelt setting is not silently ignored.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
-	return defaultValue
-}
\ No newline at end of file
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides each setting whose env tag names a set environment
+// variable, adding an error for every value that does not parse
+func applyEnv(cfg *Config, errs *[]error) {
+	walk(reflect.ValueOf(cfg).Elem(), func(f reflect.StructField, v reflect.Value) {
+		key := f.Tag.Get("env")
+		if key == "" {
+			return
+		}
+		value, ok := os.LookupEnv(key)
+		if !ok || value == "" {
+			return
+		}
+		if err := setValue(v, value); err != nil {
+			*errs = append(*errs, fmt.Errorf("invalid %s: %q", key, value))
+		}
+	})
+}
+
+// walk calls fn for every setting of the struct v, descending into sections
+func walk(v reflect.Value, fn func(f reflect.StructField, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), fn)
+			continue
+		}
+		fn(f, v.Field(i))
+	}
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		// Comma-separated, dropping empty items
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// YAML renders the configuration as a config file, with secrets that are
+// set replaced by REDACTED
+func (c *Config) YAML() ([]byte, error) {
+	copied := *c
+	walk(reflect.ValueOf(&copied).Elem(), func(f reflect.StructField, v reflect.Value) {
+		if f.Tag.Get("secret") == "true" && v.String() != "" {
+			v.SetString(redacted)
+		}
+	})
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&copied); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Changed lists the keys of the settings that differ between c and other,
+// such as "auth.password"
+func (c *Config) Changed(other *Config) []string {
+	var changed []string
+	diff(reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem(), "", &changed)
+	return changed
+}
+
+func diff(a, b reflect.Value, prefix string, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := prefix + strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			diff(a.Field(i), b.Field(i), key+".", changed)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			*changed = append(*changed, key)
+		}
+	}
+}
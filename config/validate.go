@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	clockRange = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d-([01]\d|2[0-3]):[0-5]\d$`)
+	weekdays   = map[string]bool{"sun": true, "mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true}
+)
+
+// validator collects every invalid setting, so one start reports them all
+type validator struct {
+	errs []error
+}
+
+// check adds an error for the setting at key, overridden by env, unless ok
+func (v *validator) check(ok bool, key, env string, value interface{}, problem string) {
+	if ok {
+		return
+	}
+	name := key
+	if env != "" {
+		name += " (" + env + ")"
+	}
+	v.errs = append(v.errs, fmt.Errorf("invalid %s: %v: %s", name, value, problem))
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// Validate checks every setting and returns an error listing those that are
+// invalid, one per line
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	s := c.Server
+	v.check(validPort(s.Port), "server.port", "PORT", s.Port, "use a port number")
+	v.check(s.ReadHeaderTimeout >= 0, "server.read_header_timeout", "SERVER_READ_HEADER_TIMEOUT", s.ReadHeaderTimeout, "must not be negative")
+	v.check(s.ReadTimeout >= 0, "server.read_timeout", "SERVER_READ_TIMEOUT", s.ReadTimeout, "must not be negative")
+	v.check(s.WriteTimeout >= 0, "server.write_timeout", "SERVER_WRITE_TIMEOUT", s.WriteTimeout, "must not be negative")
+	v.check(s.IdleTimeout >= 0, "server.idle_timeout", "SERVER_IDLE_TIMEOUT", s.IdleTimeout, "must not be negative")
+	v.check(s.ShutdownTimeout > 0, "server.shutdown_timeout", "SHUTDOWN_TIMEOUT", s.ShutdownTimeout, "must be positive")
+
+	a := c.Auth
+	v.check((a.Username == "") == (a.Password == ""), "auth", "", "username and password", "set both or neither")
+
+	v.check(c.Storage.Bucket != "", "storage.bucket", "BUCKET_NAME", `""`, "is required")
+	v.check(c.Storage.Region != "", "storage.region", "AWS_REGION", `""`, "is required")
+	v.check((c.Storage.AccessKeyID == "") == (c.Storage.SecretAccessKey == ""), "storage", "", "access_key_id and secret_access_key", "set both or neither")
+	if c.Storage.Endpoint != "" {
+		u, err := url.Parse(c.Storage.Endpoint)
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "storage.endpoint", "S3_ENDPOINT", c.Storage.Endpoint, "use a URL such as http://localhost:9000")
+	}
+
+	for name, camera := range c.Cameras {
+		key := "cameras." + name
+		for _, r := range camera.QuietHours {
+			v.check(clockRange.MatchString(r), key+".quiet_hours", "", r, "use HH:MM-HH:MM")
+		}
+		for _, d := range camera.Days {
+			v.check(weekdays[strings.ToLower(d)], key+".days", "", d, "use sun, mon, tue, wed, thu, fri or sat")
+		}
+	}
+
+	n := c.Notifier
+	v.check(!n.Enabled || n.Interval > 0, "notifier.interval", "NOTIFIER_INTERVAL", n.Interval, "must be positive")
+	switch n.Mode {
+	case "immediate", "batch", "hourly", "daily":
+	default:
+		v.check(false, "notifier.mode", "NOTIFY_MODE", n.Mode, "use immediate, batch, hourly or daily")
+	}
+	v.check(n.BatchWindow > 0, "notifier.batch_window", "BATCH_WINDOW", n.BatchWindow, "must be positive")
+	v.check(n.MaxRetries >= 0, "notifier.max_retries", "DISCORD_MAX_RETRIES", n.MaxRetries, "must not be negative")
+	v.check(n.MaxAttempts >= 1, "notifier.max_attempts", "NOTIFIER_MAX_ATTEMPTS", n.MaxAttempts, "must be at least 1")
+	v.check(n.LookbackDays >= 1, "notifier.lookback_days", "NOTIFIER_LOOKBACK_DAYS", n.LookbackDays, "must be at least 1")
+	// Entries must outlive the lookback window, otherwise a video whose entry
+	// was cleaned up would be notified again when its day is rescanned
+	v.check(n.RetentionDays > n.LookbackDays, "notifier.retention_days", "NOTIFIER_RETENTION_DAYS", n.RetentionDays, "must be greater than notifier.lookback_days")
+	v.check(!n.Enabled || n.DiscordWebhookURL != "" || n.ScheduleFile != "", "notifier.discord_webhook_url", "DISCORD_WEBHOOK_URL", `""`, "is required by the notifier without a schedule file")
+
+	e := c.Export
+	v.check(e.Retention > 0, "export.retention", "EXPORT_RETENTION", e.Retention, "must be positive")
+	v.check(e.MaxClips >= 1, "export.max_clips", "EXPORT_MAX_CLIPS", e.MaxClips, "must be at least 1")
+	v.check(e.MaxBytes > 0, "export.max_bytes", "EXPORT_MAX_BYTES", e.MaxBytes, "must be positive")
+	v.check(e.MaxZIPBytes > 0, "export.max_zip_bytes", "ZIP_MAX_BYTES", e.MaxZIPBytes, "must be positive")
+
+	t := c.Trash
+	v.check(strings.HasSuffix(t.Prefix, "/"), "trash.prefix", "TRASH_PREFIX", t.Prefix, "must end with /")
+	v.check(t.Retention > 0, "trash.retention", "TRASH_RETENTION", t.Retention, "must be positive")
+
+	h := c.HLS
+	v.check(h.Retention > 0, "hls.retention", "HLS_RETENTION", h.Retention, "must be positive")
+	v.check(h.MaxJobs >= 1, "hls.max_jobs", "HLS_MAX_JOBS", h.MaxJobs, "must be at least 1")
+
+	x := c.Index
+	v.check(x.Interval > 0, "index.interval", "INDEX_INTERVAL", x.Interval, "must be positive")
+	v.check(x.LookbackDays >= 0, "index.lookback_days", "INDEX_LOOKBACK_DAYS", x.LookbackDays, "must not be negative")
+
+	v.check(c.Annotations.Store == "local" || c.Annotations.Store == "s3", "annotations.store", "ANNOTATIONS_STORE", c.Annotations.Store, "use local or s3")
+
+	v.check(c.Stats.Concurrency >= 1, "stats.concurrency", "STATS_CONCURRENCY", c.Stats.Concurrency, "must be at least 1")
+	v.check(c.Stats.CacheTTL >= 0, "stats.cache_ttl", "STATS_CACHE_TTL", c.Stats.CacheTTL, "must not be negative")
+
+	v.check(c.Cache.TTL >= 0, "cache.ttl", "LISTING_CACHE_TTL", c.Cache.TTL, "must not be negative")
+	v.check(c.Cache.MaxEntries >= 1, "cache.max_entries", "LISTING_CACHE_MAX_ENTRIES", c.Cache.MaxEntries, "must be at least 1")
+
+	v.check(c.Health.Timeout > 0, "health.timeout", "HEALTH_CHECK_TIMEOUT", c.Health.Timeout, "must be positive")
+
+	tl := c.TLS
+	files := tl.CertFile != "" || tl.KeyFile != ""
+	v.check(!files || (tl.CertFile != "" && tl.KeyFile != ""), "tls", "", "cert_file and key_file", "set both or neither")
+	v.check(!files || len(tl.ACMEDomains) == 0, "tls", "", "cert_file and acme_domains", "use certificate files or ACME, not both")
+	v.check(tl.RedirectPort == "" || validPort(tl.RedirectPort), "tls.redirect_port", "TLS_REDIRECT_PORT", tl.RedirectPort, "use a port number")
+	v.check(tl.HSTSMaxAge >= 0, "tls.hsts_max_age", "HSTS_MAX_AGE", tl.HSTSMaxAge, "must not be negative")
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		v.check(false, "log.level", "LOG_LEVEL", c.Log.Level, "use debug, info, warn or error")
+	}
+	v.check(c.Log.Format == "text" || c.Log.Format == "json", "log.format", "LOG_FORMAT", c.Log.Format, "use text or json")
+
+	tr := c.Tracing
+	if tr.Enabled {
+		u, err := url.Parse(tr.Endpoint)
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "tracing.endpoint", "TRACING_ENDPOINT", tr.Endpoint, "use a URL such as http://localhost:4318")
+	}
+	v.check(tr.SampleRatio >= 0 && tr.SampleRatio <= 1, "tracing.sample_ratio", "TRACING_SAMPLE_RATIO", tr.SampleRatio, "use 0 to 1")
+
+	return errors.Join(v.errs...)
+}
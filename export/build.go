@@ -0,0 +1,235 @@
+package export
+
+import (
+	"bytes"
+	"camera-viewer/services"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (m *Manager) run(ctx context.Context, job *Job) {
+	// Wait for a free slot so large exports do not all download at once
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(job, "", nil, ctx.Err())
+		return
+	}
+
+	m.update(job, func(j *Job) {
+		j.Status = StatusRunning
+		j.Progress.Stage = "listing"
+	})
+
+	dir := m.jobDir(job.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		m.finish(job, "", nil, fmt.Errorf("failed to create export directory: %w", err))
+		return
+	}
+
+	filename, manifest, err := m.build(ctx, job, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	m.finish(job, filename, manifest, err)
+}
+
+func (m *Manager) finish(job *Job, filename string, manifest *Manifest, err error) {
+	now := time.Now()
+	expires := now.Add(m.cfg.Retention)
+
+	var size int64
+	if err == nil {
+		info, statErr := os.Stat(filepath.Join(m.jobDir(job.ID), filename))
+		if statErr != nil {
+			err = statErr
+		} else {
+			size = info.Size()
+		}
+	}
+
+	m.mu.Lock()
+	job.FinishedAt = &now
+	job.ExpiresAt = &expires
+	job.Manifest = manifest
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = StatusCanceled
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = StatusDone
+		job.Filename = filename
+		job.Size = size
+	}
+	job.Progress.Stage = job.Status
+	_, tracked := m.jobs[job.ID]
+	delete(m.cancels, job.ID)
+	delete(m.reserved, job.ID)
+	m.mu.Unlock()
+
+	// A job deleted while it was running leaves no files behind
+	if !tracked {
+		os.RemoveAll(m.jobDir(job.ID))
+		return
+	}
+
+	switch {
+	case err == nil:
+		slog.Info("Export finished", "export", job.ID, "clips", len(manifest.Clips), "bytes", size)
+	case job.Status == StatusCanceled:
+		slog.Info("Export canceled", "export", job.ID)
+	default:
+		slog.Error("Export failed", "export", job.ID, "error", err)
+	}
+}
+
+// build selects the clips for a job and writes the export into dir,
+// returning the name of the file written
+func (m *Manager) build(ctx context.Context, job *Job, dir string) (string, *Manifest, error) {
+	req := job.Request
+
+	// Keys and filenames use the cameras' local time
+	timeline, err := m.s3Service.Timeline(ctx, req.Start.In(time.Local), req.End.In(time.Local), req.Camera, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manifest := &Manifest{
+		Bucket:    m.s3Service.BucketName(),
+		Camera:    req.Camera,
+		Start:     req.Start,
+		End:       req.End,
+		CreatedAt: time.Now().UTC(),
+		Clips:     []ManifestClip{},
+	}
+
+	var clips []services.Clip
+	for _, clip := range timeline.Clips {
+		if !clip.Playable {
+			manifest.Skipped = append(manifest.Skipped, SkippedClip{
+				Key:    clip.Key,
+				Reason: fmt.Sprintf("stored in %s, restore it first", clip.StorageClass),
+			})
+			continue
+		}
+		clips = append(clips, clip)
+	}
+	if len(clips) == 0 {
+		return "", manifest, fmt.Errorf("no downloadable clips in the selected range")
+	}
+	if len(clips) > m.cfg.MaxClips {
+		return "", manifest, fmt.Errorf("%d clips in the selected range, the limit is %d", len(clips), m.cfg.MaxClips)
+	}
+
+	// Nothing is downloaded unless the export fits in EXPORT_MAX_BYTES. An
+	// MP4 export holds the downloaded clips and the joined video at once.
+	var size int64
+	for _, clip := range clips {
+		size += clip.Size
+	}
+	if req.Format == FormatMP4 {
+		size *= 2
+	}
+	if err := m.reserve(job, size); err != nil {
+		return "", manifest, err
+	}
+
+	m.update(job, func(j *Job) {
+		j.Progress = Progress{Stage: "downloading", Total: len(clips)}
+	})
+	progress := func() {
+		m.update(job, func(j *Job) { j.Progress.Done++ })
+	}
+
+	switch req.Format {
+	case FormatZIP:
+		err = m.buildZIP(ctx, filepath.Join(dir, "export.zip"), clips, manifest, progress)
+		return "export.zip", manifest, err
+	default:
+		err = m.buildMP4(ctx, job, dir, clips, manifest, progress)
+		return "export.mp4", manifest, err
+	}
+}
+
+func (m *Manager) buildZIP(ctx context.Context, path string, clips []services.Clip, manifest *Manifest, progress func()) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := WriteZIP(ctx, f, m.s3Service, clips, manifest, progress); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (m *Manager) buildMP4(ctx context.Context, job *Job, dir string, clips []services.Clip, manifest *Manifest, progress func()) error {
+	clipDir := filepath.Join(dir, "clips")
+	if err := os.MkdirAll(clipDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(clipDir)
+
+	// The concat demuxer reads a list of local files in playback order
+	var list strings.Builder
+	for i, clip := range clips {
+		path := filepath.Join(clipDir, fmt.Sprintf("%05d.mp4", i))
+		if err := downloadClip(ctx, path, m.s3Service, clip, manifest); err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", path)
+		progress()
+	}
+
+	listPath := filepath.Join(clipDir, "list.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+
+	m.update(job, func(j *Job) { j.Progress.Stage = "concatenating" })
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, m.cfg.FFmpegPath,
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-f", "concat", "-safe", "0", "-i", listPath,
+		"-c", "copy", "-movflags", "+faststart",
+		filepath.Join(dir, "export.mp4"),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg failed: %v: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return nil
+}
+
+func downloadClip(ctx context.Context, path string, s3Service *services.S3Service, clip services.Clip, manifest *Manifest) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mc, err := copyClip(ctx, f, s3Service, clip)
+	if err != nil {
+		return err
+	}
+	manifest.Clips = append(manifest.Clips, mc)
+	return f.Close()
+}
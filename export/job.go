@@ -0,0 +1,119 @@
+package export
+
+import (
+	"fmt"
+	"time"
+)
+
+// Export formats
+const (
+	// FormatMP4 concatenates the clips of one camera into a single video
+	FormatMP4 = "mp4"
+	// FormatZIP packages the original clips with a manifest
+	FormatZIP = "zip"
+)
+
+// Job states
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+)
+
+// Request selects the clips to export
+type Request struct {
+	Camera string    `json:"camera"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Format string    `json:"format"`
+}
+
+func (r Request) validate() error {
+	switch r.Format {
+	case FormatMP4:
+		// Clips from different cameras rarely share codec settings, which
+		// the concat demuxer requires
+		if r.Camera == "" {
+			return fmt.Errorf("camera is required for mp4 exports")
+		}
+	case FormatZIP:
+	default:
+		return fmt.Errorf("invalid format %q (use mp4 or zip)", r.Format)
+	}
+	if r.Start.IsZero() || r.End.IsZero() {
+		return fmt.Errorf("start and end are required")
+	}
+	if !r.End.After(r.Start) {
+		return fmt.Errorf("end must be after start")
+	}
+	if r.End.Sub(r.Start) > 7*24*time.Hour {
+		return fmt.Errorf("export range cannot be longer than 7 days")
+	}
+	return nil
+}
+
+// Progress counts the clips handled so far
+type Progress struct {
+	Stage string `json:"stage"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// Job is the state of one export as reported by the API
+type Job struct {
+	ID         string     `json:"id"`
+	Request    Request    `json:"request"`
+	Status     string     `json:"status"`
+	Progress   Progress   `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Filename   string     `json:"filename,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+	Manifest   *Manifest  `json:"manifest,omitempty"`
+}
+
+// Manifest describes the clips in an export. It is included in ZIP exports
+// as manifest.json so recipients can verify the files.
+type Manifest struct {
+	Bucket    string         `json:"bucket"`
+	Camera    string         `json:"camera,omitempty"`
+	Prefix    string         `json:"prefix,omitempty"`
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	CreatedAt time.Time      `json:"created_at"`
+	Clips     []ManifestClip `json:"clips"`
+	Skipped   []SkippedClip  `json:"skipped,omitempty"`
+}
+
+// ManifestClip is one exported clip with the checksum of its contents
+type ManifestClip struct {
+	Key    string    `json:"key"`
+	Camera string    `json:"camera"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+}
+
+// SkippedClip is a clip in the range that could not be exported
+type SkippedClip struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed || j.Status == StatusCanceled
+}
+
+// downloadName is the filename offered to the browser
+func (j *Job) downloadName() string {
+	camera := j.Request.Camera
+	if camera == "" {
+		camera = "all"
+	}
+	return fmt.Sprintf("export-%s-%s.%s", camera, j.Request.Start.Format("20060102-1504"), j.Request.Format)
+}
@@ -0,0 +1,267 @@
+package export
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxConcurrentJobs limits how many exports download from S3 at once
+const maxConcurrentJobs = 2
+
+// dirPrefix marks the working directories of export jobs inside the export
+// directory
+const dirPrefix = "export-"
+
+var (
+	ErrNotFound = errors.New("export not found")
+	ErrNotReady = errors.New("export is not finished")
+)
+
+// Manager runs export jobs in the background and keeps their results on disk
+// until they expire. Jobs are held in memory, so exports do not survive a
+// restart.
+type Manager struct {
+	cfg       config.ExportConfig
+	s3Service *services.S3Service
+
+	ctx   context.Context
+	slots chan struct{}
+	// wg tracks the cleanup loop and the running jobs
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	// reserved is the disk space set aside for each running job, which is
+	// replaced by the size of its file once it finishes
+	reserved map[string]int64
+}
+
+func NewManager(cfg config.ExportConfig, s3Service *services.S3Service) (*Manager, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	// Results of jobs from a previous run can no longer be downloaded
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), dirPrefix) {
+			os.RemoveAll(filepath.Join(cfg.Dir, entry.Name()))
+		}
+	}
+
+	return &Manager{
+		cfg:       cfg,
+		s3Service: s3Service,
+		ctx:       context.Background(),
+		slots:     make(chan struct{}, maxConcurrentJobs),
+		jobs:      make(map[string]*Job),
+		cancels:   make(map[string]context.CancelFunc),
+		reserved:  make(map[string]int64),
+	}, nil
+}
+
+// Start removes expired exports until ctx is done. Running jobs are
+// canceled when ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.removeExpired()
+			}
+		}
+	}()
+}
+
+// Create queues an export and returns immediately; poll Get for progress
+func (m *Manager) Create(req Request) (Job, error) {
+	if err := req.validate(); err != nil {
+		return Job{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := &Job{
+		ID:        id,
+		Request:   req,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.jobs[id] = job
+	m.cancels[id] = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run(ctx, job)
+	}()
+	return *job, nil
+}
+
+// Wait blocks until the cleanup loop and the jobs have stopped, which they
+// do once the context passed to Start is done
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+// List returns all known exports, newest first
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Delete cancels an export if it is still running and removes its files
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	_, ok := m.jobs[id]
+	cancel := m.cancels[id]
+	delete(m.jobs, id)
+	delete(m.cancels, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return os.RemoveAll(m.jobDir(id))
+}
+
+// Open returns the finished export file for download along with the name
+// to offer it under. The caller closes the file.
+func (m *Manager) Open(id string) (*os.File, string, error) {
+	job, err := m.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if job.Status != StatusDone {
+		return nil, "", ErrNotReady
+	}
+
+	f, err := os.Open(filepath.Join(m.jobDir(id), job.Filename))
+	if err != nil {
+		return nil, "", err
+	}
+	return f, job.downloadName(), nil
+}
+
+// reserve sets aside size bytes of EXPORT_MAX_BYTES for a job, failing if
+// the running and finished exports leave too little of it
+func (m *Manager) reserve(job *Job, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var used int64
+	for _, j := range m.jobs {
+		if j.Status == StatusDone {
+			used += j.Size
+		}
+	}
+	for _, n := range m.reserved {
+		used += n
+	}
+	if size > m.cfg.MaxBytes {
+		return fmt.Errorf("export needs %d bytes of disk space, the limit is %d", size, m.cfg.MaxBytes)
+	}
+	if used+size > m.cfg.MaxBytes {
+		return fmt.Errorf("export needs %d bytes of disk space but only %d of %d are free; delete finished exports to make room", size, m.cfg.MaxBytes-used, m.cfg.MaxBytes)
+	}
+	m.reserved[job.ID] = size
+	return nil
+}
+
+func (m *Manager) jobDir(id string) string {
+	return filepath.Join(m.cfg.Dir, dirPrefix+id)
+}
+
+func (m *Manager) removeExpired() {
+	now := time.Now()
+
+	m.mu.Lock()
+	var expired []string
+	for id, job := range m.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			expired = append(expired, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range expired {
+		if err := m.Delete(id); err != nil && !errors.Is(err, ErrNotFound) {
+			slog.Warn("Failed to remove expired export", "export", id, "error", err)
+		}
+	}
+	if len(expired) > 0 {
+		slog.Info("Removed expired exports", "count", len(expired))
+	}
+}
+
+// update applies fn to a job's state under the lock
+func (m *Manager) update(job *Job, fn func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(job)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate export id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
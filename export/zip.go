@@ -0,0 +1,79 @@
+package export
+
+import (
+	"archive/zip"
+	"camera-viewer/services"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WriteZIP streams clips from S3 into a ZIP archive on w, followed by the
+// manifest with each clip's checksum. progress, if set, is called after each
+// clip. Videos are stored uncompressed since MP4 does not compress further.
+func WriteZIP(ctx context.Context, w io.Writer, s3Service *services.S3Service, clips []services.Clip, manifest *Manifest, progress func()) error {
+	zw := zip.NewWriter(w)
+
+	for _, clip := range clips {
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     clip.Key,
+			Method:   zip.Store,
+			Modified: clip.End,
+		})
+		if err != nil {
+			return err
+		}
+
+		mc, err := copyClip(ctx, entry, s3Service, clip)
+		if err != nil {
+			return err
+		}
+		manifest.Clips = append(manifest.Clips, mc)
+		if progress != nil {
+			progress()
+		}
+	}
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "manifest.json",
+		Method:   zip.Deflate,
+		Modified: manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// copyClip writes a clip's contents to w and describes it for the manifest
+func copyClip(ctx context.Context, w io.Writer, s3Service *services.S3Service, clip services.Clip) (ManifestClip, error) {
+	body, err := s3Service.DownloadObject(ctx, clip.Key)
+	if err != nil {
+		return ManifestClip{}, err
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, h), body)
+	if err != nil {
+		return ManifestClip{}, fmt.Errorf("failed to download %s: %w", clip.Key, err)
+	}
+
+	return ManifestClip{
+		Key:    clip.Key,
+		Camera: clip.Camera,
+		Start:  clip.Start,
+		End:    clip.End,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
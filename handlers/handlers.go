@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"camera-viewer/services"
+	"camera-viewer/trash"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -13,13 +14,15 @@ import (
 
 type Handler struct {
 	s3Service *services.S3Service
+	trashBin  *trash.Bin
 	templates *template.Template
 }
 
-func New(s3Service *services.S3Service) *Handler {
+func New(s3Service *services.S3Service, trashBin *trash.Bin) *Handler {
 	templates := template.Must(template.ParseGlob("templates/*.html"))
 	return &Handler{
 		s3Service: s3Service,
+		trashBin:  trashBin,
 		templates: templates,
 	}
 }
@@ -158,10 +161,18 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.s3Service.DeleteObject(r.Context(), key)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Clips go to the trash unless permanent=true is given
+	if r.URL.Query().Get("permanent") == "true" {
+		if err := h.s3Service.DeleteObject(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		_, failed := h.trashBin.Delete(r.Context(), []string{key})
+		if len(failed) > 0 {
+			http.Error(w, failed[0].Error, http.StatusInternalServerError)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -169,4 +180,4 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		"message": "Delete successful",
 		"key":     key,
 	})
-}
\ No newline at end of file
+}
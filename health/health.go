@@ -0,0 +1,120 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Check statuses
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Result is the outcome of one dependency check. Error is for the log only:
+// the readiness endpoint needs no credentials, and errors can name the
+// bucket, local paths and database details.
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"-"`
+}
+
+// Report is the outcome of every check. Status is "ready" when all passed.
+type Report struct {
+	Status    string            `json:"status"`
+	Timestamp time.Time         `json:"timestamp"`
+	Checks    map[string]Result `json:"checks"`
+}
+
+// Ready reports whether every check passed
+func (r Report) Ready() bool {
+	return r.Status == "ready"
+}
+
+type check struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Checker runs the readiness checks of the server's dependencies
+type Checker struct {
+	timeout time.Duration
+	checks  []check
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check. Register before serving requests.
+func (c *Checker) Add(name string, fn func(ctx context.Context) error) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// Run runs every check in parallel, each bounded by the timeout
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status:    "ready",
+		Timestamp: time.Now().UTC(),
+		Checks:    make(map[string]Result, len(c.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, ch := range c.checks {
+		wg.Add(1)
+		go func(ch check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			// A check that ignores ctx, such as one on a hung disk, is
+			// abandoned rather than holding up the report
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() {
+				done <- ch.fn(ctx)
+			}()
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				err = fmt.Errorf("timed out after %s", c.timeout)
+			}
+			result := Result{
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[ch.name] = result
+			if err != nil {
+				report.Status = "not_ready"
+			}
+		}(ch)
+	}
+	wg.Wait()
+	return report
+}
+
+// WritableDir checks that files can be created in dir
+func WritableDir(dir string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		f.Close()
+		return os.Remove(name)
+	}
+}
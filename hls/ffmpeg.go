@@ -0,0 +1,151 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// segmentSeconds is the target length of HLS segments
+	segmentSeconds = 4
+	// audioKbps is the AAC bitrate of every rendition
+	audioKbps = 128
+)
+
+// rendition is one quality level of a stream
+type rendition struct {
+	Height int
+	// Kbps is the target video bitrate
+	Kbps int
+}
+
+func (r rendition) name() string {
+	return fmt.Sprintf("%dp", r.Height)
+}
+
+// parseRenditions parses a list like "720:2800k,480:1200k", returning the
+// renditions highest first
+func parseRenditions(s string) ([]rendition, error) {
+	var renditions []rendition
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		height, bitrate, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not height:bitrate", part)
+		}
+		h, err := strconv.Atoi(height)
+		if err != nil || h < 144 || h%2 != 0 {
+			return nil, fmt.Errorf("invalid height %q", height)
+		}
+		kbps, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(bitrate), "k"))
+		if err != nil || kbps <= 0 {
+			return nil, fmt.Errorf("invalid bitrate %q (use kilobits, for example 2800k)", bitrate)
+		}
+		if seen[h] {
+			return nil, fmt.Errorf("height %d is listed twice", h)
+		}
+		seen[h] = true
+		renditions = append(renditions, rendition{Height: h, Kbps: kbps})
+	}
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("no renditions")
+	}
+
+	sort.Slice(renditions, func(i, j int) bool {
+		return renditions[i].Height > renditions[j].Height
+	})
+	return renditions, nil
+}
+
+// transcode downloads a clip and writes its renditions and master playlist
+// into dir
+func (t *Transcoder) transcode(ctx context.Context, key, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	source := filepath.Join(dir, "source.mp4")
+	if err := t.download(ctx, key, source); err != nil {
+		return err
+	}
+	defer os.Remove(source)
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range t.renditions {
+		if err := t.encode(ctx, source, dir, r); err != nil {
+			return err
+		}
+		// BANDWIDTH is the peak rate; allow for the encoder's overshoot
+		bandwidth := (r.Kbps*11/10 + audioKbps) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,NAME=%q\n%s.m3u8\n", bandwidth, r.name(), r.name())
+	}
+
+	return os.WriteFile(filepath.Join(dir, MasterPlaylist), []byte(master.String()), 0644)
+}
+
+func (t *Transcoder) download(ctx context.Context, key, path string) error {
+	body, err := t.s3Service.DownloadObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return f.Close()
+}
+
+// encode writes one rendition. Clips smaller than the rendition are not
+// scaled up, and keyframes are forced on segment boundaries so players can
+// switch renditions between segments.
+func (t *Transcoder) encode(ctx context.Context, source, dir string, r rendition) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.cfg.FFmpegPath,
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", source,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=-2:min(%d\\,ih)", r.Height),
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-pix_fmt", "yuv420p",
+		"-b:v", fmt.Sprintf("%dk", r.Kbps),
+		"-maxrate", fmt.Sprintf("%dk", r.Kbps*11/10),
+		"-bufsize", fmt.Sprintf("%dk", r.Kbps*2),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds),
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioKbps), "-ac", "2",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(segmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, r.name()+"_%04d.ts"),
+		filepath.Join(dir, r.name()+".m3u8"),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg failed for %s: %v: %s", r.name(), err, msg)
+		}
+		return fmt.Errorf("ffmpeg failed for %s: %w", r.name(), err)
+	}
+	return nil
+}
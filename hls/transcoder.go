@@ -0,0 +1,268 @@
+package hls
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MasterPlaylist is the name of the playlist listing a clip's renditions
+const MasterPlaylist = "master.m3u8"
+
+// tmpSuffix marks directories of transcodes still in progress
+const tmpSuffix = ".tmp"
+
+// Stream states
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+var (
+	ErrArchived = errors.New("clip is archived, restore it first")
+	ErrNotFound = errors.New("file not found")
+)
+
+// Stream is the HLS state of one clip as reported by the API
+type Stream struct {
+	Key    string `json:"key"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Playlist is the path of the master playlist, relative to the HLS
+	// file endpoint, once the stream is ready
+	Playlist string `json:"playlist,omitempty"`
+}
+
+// Transcoder converts clips into HLS renditions on demand and caches them on
+// disk. A clip is transcoded again if it is overwritten in S3.
+type Transcoder struct {
+	cfg        config.HLSConfig
+	s3Service  *services.S3Service
+	renditions []rendition
+
+	ctx   context.Context
+	slots chan struct{}
+	// wg tracks the cleanup loop and the running transcodes
+	wg sync.WaitGroup
+
+	mu sync.Mutex
+	// jobs holds transcodes that are queued, running or failed, by id
+	jobs map[string]*Stream
+}
+
+func New(cfg config.HLSConfig, s3Service *services.S3Service) (*Transcoder, error) {
+	renditions, err := parseRenditions(cfg.Renditions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HLS_RENDITIONS: %w", err)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create HLS directory: %w", err)
+	}
+
+	// Transcodes interrupted by a restart are incomplete
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HLS directory: %w", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), tmpSuffix) {
+			os.RemoveAll(filepath.Join(cfg.Dir, entry.Name()))
+		}
+	}
+
+	return &Transcoder{
+		cfg:        cfg,
+		s3Service:  s3Service,
+		renditions: renditions,
+		ctx:        context.Background(),
+		slots:      make(chan struct{}, cfg.MaxJobs),
+		jobs:       make(map[string]*Stream),
+	}, nil
+}
+
+// Start removes cached streams that have not been requested for longer than
+// the retention period, until ctx is done. Running transcodes are canceled
+// when ctx is done.
+func (t *Transcoder) Start(ctx context.Context) {
+	t.mu.Lock()
+	t.ctx = ctx
+	t.mu.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.removeExpired()
+			}
+		}
+	}()
+}
+
+// Wait blocks until the cleanup loop and the transcodes have stopped, which
+// they do once the context passed to Start is done
+func (t *Transcoder) Wait() {
+	t.wg.Wait()
+}
+
+// Prepare returns the HLS state of a clip, queueing a transcode if there is
+// no stream for the current version of the clip yet
+func (t *Transcoder) Prepare(ctx context.Context, key string) (Stream, error) {
+	video, err := t.s3Service.StatVideo(ctx, key)
+	if err != nil {
+		return Stream{}, err
+	}
+	if video.StorageClass == "GLACIER" || video.StorageClass == "DEEP_ARCHIVE" {
+		return Stream{}, ErrArchived
+	}
+
+	id := streamID(key, video.LastModified)
+	dir := filepath.Join(t.cfg.Dir, id)
+	if _, err := os.Stat(filepath.Join(dir, MasterPlaylist)); err == nil {
+		// The directory's time records when the stream was last used
+		now := time.Now()
+		os.Chtimes(dir, now, now)
+		return Stream{Key: key, ID: id, Status: StatusReady, Playlist: id + "/" + MasterPlaylist}, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job, ok := t.jobs[id]; ok {
+		return *job, nil
+	}
+	job := &Stream{Key: key, ID: id, Status: StatusQueued}
+	t.jobs[id] = job
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.run(t.ctx, job)
+	}()
+	return *job, nil
+}
+
+// File returns the local path of a file of a ready stream, given its path
+// relative to the HLS file endpoint
+func (t *Transcoder) File(name string) (string, error) {
+	id, file, ok := strings.Cut(name, "/")
+	if !ok || id == "" || strings.HasSuffix(id, tmpSuffix) || strings.ContainsAny(file, `/\`) ||
+		(!strings.HasSuffix(file, ".m3u8") && !strings.HasSuffix(file, ".ts")) ||
+		id != filepath.Base(id) || file != filepath.Base(file) {
+		return "", ErrNotFound
+	}
+
+	path := filepath.Join(t.cfg.Dir, id, file)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+func (t *Transcoder) run(ctx context.Context, job *Stream) {
+	select {
+	case t.slots <- struct{}{}:
+		defer func() { <-t.slots }()
+	case <-ctx.Done():
+		t.finish(job, ctx.Err())
+		return
+	}
+
+	t.update(job, func(s *Stream) { s.Status = StatusRunning })
+
+	started := time.Now()
+	tmp := filepath.Join(t.cfg.Dir, job.ID+tmpSuffix)
+	err := t.transcode(ctx, job.Key, tmp)
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(t.cfg.Dir, job.ID))
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+	} else {
+		slog.Info("Transcoded clip to HLS", "key", job.Key, "duration", time.Since(started).Round(time.Second).String())
+	}
+	t.finish(job, err)
+}
+
+// finish forgets a successful job, whose stream is now found on disk, and
+// keeps a failed one so the clip is not transcoded over and over
+func (t *Transcoder) finish(job *Stream, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil || errors.Is(err, context.Canceled) {
+		delete(t.jobs, job.ID)
+		return
+	}
+	job.Status = StatusFailed
+	job.Error = err.Error()
+	slog.Error("Failed to transcode clip to HLS", "key", job.Key, "error", err)
+}
+
+func (t *Transcoder) removeExpired() {
+	entries, err := os.ReadDir(t.cfg.Dir)
+	if err != nil {
+		slog.Error("Failed to read HLS directory", "error", err)
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), tmpSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < t.cfg.Retention {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(t.cfg.Dir, entry.Name())); err != nil {
+			slog.Warn("Failed to remove HLS stream", "stream", entry.Name(), "error", err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		slog.Info("Removed unused HLS streams", "count", removed)
+	}
+
+	// Failed transcodes are tried again on the next request
+	t.mu.Lock()
+	for id, job := range t.jobs {
+		if job.Status == StatusFailed {
+			delete(t.jobs, id)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// update applies fn to a job's state under the lock
+func (t *Transcoder) update(job *Stream, fn func(*Stream)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(job)
+}
+
+// streamID names the stream of one version of a clip
+func streamID(key string, lastModified time.Time) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + strconv.FormatInt(lastModified.UnixNano(), 10)))
+	return hex.EncodeToString(sum[:16])
+}
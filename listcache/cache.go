@@ -0,0 +1,307 @@
+package listcache
+
+import (
+	"camera-viewer/config"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// saveInterval is how often a changed cache is written to its file
+const saveInterval = time.Minute
+
+type entry struct {
+	// Prefix is the part of the bucket the listing shows
+	Prefix  string    `json:"prefix"`
+	ETag    string    `json:"etag"`
+	Body    []byte    `json:"body"`
+	Expires time.Time `json:"expires"`
+}
+
+// Stats counts how requests were answered since the server started
+type Stats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	NotModified   int64   `json:"not_modified"`
+	Invalidations int64   `json:"invalidations"`
+	Evictions     int64   `json:"evictions"`
+	Entries       int     `json:"entries"`
+	HitRate       float64 `json:"hit_rate"`
+}
+
+// Cache keeps the JSON responses of bucket listings by request URL, and
+// answers conditional requests from their ETags. Entries expire after the
+// TTL and are removed early when an object below their prefix changes.
+type Cache struct {
+	ttl        time.Duration
+	file       string
+	maxEntries int
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	dirty   bool
+	stats   Stats
+	// generation counts invalidations, so a listing made before one is
+	// not stored after it
+	generation uint64
+}
+
+func New(cfg config.CacheConfig) *Cache {
+	c := &Cache{
+		ttl:        cfg.TTL,
+		file:       cfg.File,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[string]*entry),
+	}
+	if c.file != "" {
+		if err := c.load(); err != nil {
+			slog.Warn("Ignoring listing cache file", "file", c.file, "error", err)
+		}
+	}
+	return c
+}
+
+// Start writes the cache to its file every minute, when it has one, until
+// ctx is done
+func (c *Cache) Start(ctx context.Context) {
+	if c.file == "" {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.save(); err != nil {
+					slog.Error("Failed to save listing cache", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Wait blocks until the save loop has stopped, after ctx is done
+func (c *Cache) Wait() {
+	c.wg.Wait()
+}
+
+// Close writes the cache to its file
+func (c *Cache) Close() error {
+	if c.file == "" {
+		return nil
+	}
+	return c.save()
+}
+
+// Serve answers r from the cache and reports whether it did. Otherwise it
+// returns the generation to pass to Write with the listing.
+func (c *Cache) Serve(w http.ResponseWriter, r *http.Request) (generation uint64, served bool) {
+	key := requestKey(r)
+
+	c.mu.Lock()
+	generation = c.generation
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.Expires) {
+		delete(c.entries, key)
+		c.dirty = true
+		ok = false
+	}
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return generation, false
+	}
+	w.Header().Set("X-Cache", "HIT")
+	c.write(w, r, e.ETag, e.Body)
+	return generation, true
+}
+
+// Write encodes v as the JSON response to r with an ETag, keeping it for
+// later requests when store is set. prefix is the part of the bucket the
+// response lists; a change below it removes the response from the cache.
+// generation is the one Serve returned before the listing was made; the
+// response is not kept if the cache was invalidated since, as it may show
+// the bucket from before the change.
+func (c *Cache) Write(w http.ResponseWriter, r *http.Request, generation uint64, prefix string, v interface{}, store bool) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	if store && c.ttl > 0 {
+		c.mu.Lock()
+		if generation == c.generation {
+			if len(c.entries) >= c.maxEntries {
+				c.evict()
+			}
+			c.entries[requestKey(r)] = &entry{
+				Prefix:  prefix,
+				ETag:    etag,
+				Body:    body,
+				Expires: time.Now().Add(c.ttl),
+			}
+			c.dirty = true
+		}
+		c.mu.Unlock()
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+	c.write(w, r, etag, body)
+}
+
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, etag string, body []byte) {
+	w.Header().Set("ETag", etag)
+	// Browsers keep the response but check it is current on every use
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		c.mu.Lock()
+		c.stats.NotModified++
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Invalidate removes the listings showing key, such as the day, month and
+// year listings of a clip and the list of years
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for k, e := range c.entries {
+		if strings.HasPrefix(key, e.Prefix) {
+			delete(c.entries, k)
+			c.stats.Invalidations++
+			c.dirty = true
+		}
+	}
+}
+
+// Clear removes every listing
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.stats.Invalidations += int64(len(c.entries))
+	c.entries = make(map[string]*entry)
+	c.dirty = true
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// evict makes room for an entry by removing expired entries or, when none
+// have expired, the one expiring first. Called with mu held.
+func (c *Cache) evict() {
+	now := time.Now()
+	var first string
+	for k, e := range c.entries {
+		if now.After(e.Expires) {
+			delete(c.entries, k)
+			continue
+		}
+		if first == "" || e.Expires.Before(c.entries[first].Expires) {
+			first = k
+		}
+	}
+	if len(c.entries) >= c.maxEntries && first != "" {
+		delete(c.entries, first)
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) load() error {
+	data, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries map[string]*entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for k, e := range entries {
+		if now.Before(e.Expires) && len(c.entries) < c.maxEntries {
+			c.entries[k] = e
+		}
+	}
+	return nil
+}
+
+func (c *Cache) save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := c.file + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, c.file)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// requestKey identifies a listing by its path and sorted query parameters
+func requestKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+func matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
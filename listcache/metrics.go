@@ -0,0 +1,33 @@
+package listcache
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	hitsDesc          = prometheus.NewDesc("camera_viewer_listing_cache_hits_total", "Listing requests answered from the cache.", nil, nil)
+	missesDesc        = prometheus.NewDesc("camera_viewer_listing_cache_misses_total", "Listing requests that had to list the bucket.", nil, nil)
+	notModifiedDesc   = prometheus.NewDesc("camera_viewer_listing_cache_not_modified_total", "Listing requests answered with 304 Not Modified.", nil, nil)
+	invalidationsDesc = prometheus.NewDesc("camera_viewer_listing_cache_invalidations_total", "Cached listings removed because the bucket changed.", nil, nil)
+	evictionsDesc     = prometheus.NewDesc("camera_viewer_listing_cache_evictions_total", "Cached listings removed to make room.", nil, nil)
+	entriesDesc       = prometheus.NewDesc("camera_viewer_listing_cache_entries", "Listings in the cache.", nil, nil)
+	hitRatioDesc      = prometheus.NewDesc("camera_viewer_listing_cache_hit_ratio", "Share of listing requests answered from the cache since startup.", nil, nil)
+)
+
+// Describe sends the descriptions of the cache's metrics, making the cache
+// a prometheus.Collector
+func (c *Cache) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{hitsDesc, missesDesc, notModifiedDesc, invalidationsDesc, evictionsDesc, entriesDesc, hitRatioDesc} {
+		ch <- d
+	}
+}
+
+// Collect reports the cache's Stats
+func (c *Cache) Collect(ch chan<- prometheus.Metric) {
+	stats := c.Stats()
+	ch <- prometheus.MustNewConstMetric(hitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(missesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(notModifiedDesc, prometheus.CounterValue, float64(stats.NotModified))
+	ch <- prometheus.MustNewConstMetric(invalidationsDesc, prometheus.CounterValue, float64(stats.Invalidations))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(stats.Entries))
+	ch <- prometheus.MustNewConstMetric(hitRatioDesc, prometheus.GaugeValue, stats.HitRate)
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"camera-viewer/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	level  slog.LevelVar
+	access atomic.Bool
+)
+
+// Setup makes slog's default logger write records of at least the level in
+// the format to stderr. Messages of the log package go through it too, at
+// info level.
+func Setup(cfg config.LogConfig) error {
+	if err := Reload(cfg); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: &level}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case FormatText:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q (use text or json)", cfg.Format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// Reload applies the level and access log settings of cfg to the running
+// logger. The format is fixed at Setup.
+func Reload(cfg config.LogConfig) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q (use debug, info, warn or error)", cfg.Level)
+	}
+	level.Set(l)
+	access.Store(cfg.Access)
+	return nil
+}
+
+// contextHandler adds the request ID and trace of the record's context, so
+// anything logged with a request's context can be traced back to it
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := fromContext(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"camera-viewer/response"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in requests from a proxy and in
+// every response
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID accepts IDs from proxies that are safe to log and echo
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type contextKey struct{}
+
+// requestInfo is what the access log needs to know from inside handlers
+type requestInfo struct {
+	id string
+
+	mu   sync.Mutex
+	user string
+}
+
+func fromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(contextKey{}).(*requestInfo)
+	return info
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty outside one
+func RequestID(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// SetUser records the authenticated user of the request ctx belongs to for
+// its access log
+func SetUser(ctx context.Context, user string) {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		info.user = user
+		info.mu.Unlock()
+	}
+}
+
+// Middleware gives each request an ID, taken from a valid X-Request-ID
+// header or generated, which is added to the request's context, its trace
+// span and its response. With the access log on, each request is logged
+// once it has been answered, with the mux route that served it.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		info := &requestInfo{id: id}
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, info))
+		w.Header().Set(RequestIDHeader, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		if !access.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := response.NewRecorder(w)
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		info.mu.Lock()
+		user := info.user
+		info.mu.Unlock()
+
+		level := slog.LevelInfo
+		if rec.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", rec.Status),
+			slog.Int64("bytes", rec.Bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("user", user),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
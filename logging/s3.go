@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// AWSMiddleware logs the S3 calls of a client, with the request ID of the
+// HTTP request that made them; add it to the client's APIOptions. Calls are
+// logged at debug level and failures at warn level, except cancellations.
+func AWSMiddleware(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("CameraViewerLogging",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleDeserialize(ctx, in)
+
+			level := slog.LevelDebug
+			if err != nil && !errors.Is(err, context.Canceled) {
+				level = slog.LevelWarn
+			}
+			if !slog.Default().Enabled(ctx, level) {
+				return out, md, err
+			}
+			attrs := []slog.Attr{
+				slog.String("operation", awsmiddleware.GetOperationName(ctx)),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			}
+			if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+				attrs = append(attrs, slog.String("s3_request_id", id))
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			slog.LogAttrs(ctx, level, "s3 call", attrs...)
+			return out, md, err
+		}), middleware.Before)
+}
@@ -3,26 +3,53 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"camera-viewer/annotations"
+	"camera-viewer/bulk"
+	"camera-viewer/certs"
+	"camera-viewer/clipindex"
+	"camera-viewer/config"
+	"camera-viewer/export"
+	"camera-viewer/health"
+	"camera-viewer/hls"
+	"camera-viewer/listcache"
+	"camera-viewer/logging"
+	"camera-viewer/metrics"
+	"camera-viewer/notifier"
+	"camera-viewer/services"
+	"camera-viewer/stats"
+	"camera-viewer/tracing"
+	"camera-viewer/trash"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/joho/godotenv"
-	"path/filepath"
-	"strings"
-	"time"
 )
 
+// credentials are the basic auth username and password, replaced when the
+// configuration is reloaded
+var credentials atomic.Pointer[config.AuthConfig]
+
 // basicAuth middleware to protect endpoints
 func basicAuth(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		username := os.Getenv("USERNAME")
-		password := os.Getenv("PASSWORD")
+		auth := credentials.Load()
+		username := auth.Username
+		password := auth.Password
 		
 		// If no credentials are set, allow access (for backward compatibility)
 		if username == "" || password == "" {
@@ -34,52 +61,165 @@ func basicAuth(handler http.HandlerFunc) http.HandlerFunc {
 		user, pass, ok := r.BasicAuth()
 		
 		if !ok || user != username || pass != password {
+			metrics.AuthFailure()
+			slog.WarnContext(r.Context(), "Authentication failed", "user", user, "remote", r.RemoteAddr)
 			// Set WWW-Authenticate header to prompt for credentials
 			w.Header().Set("WWW-Authenticate", `Basic realm="Camera Viewer"`)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		logging.SetUser(r.Context(), user)
 		
 		handler(w, r)
 	}
 }
 
 func main() {
+	configFile := flag.String("config", "", "configuration file (default $CONFIG_FILE or ./config.yaml)")
+	printConfig := flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	flag.Parse()
+
+	// A server that fails exits non-zero, once the deferred calls have
+	// closed the databases
+	failed := false
+	defer func() {
+		if failed {
+			os.Exit(1)
+		}
+	}()
+
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
 	}
 
-	ctx := context.Background()
-	var cfg aws.Config
-	var err error
+	// ctx stops the background workers once the server has shut down;
+	// handlers use their request's context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	if os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "" {
-		cfg, err = config.LoadDefaultConfig(ctx,
-			config.WithRegion(os.Getenv("AWS_REGION")),
-			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-				os.Getenv("AWS_ACCESS_KEY_ID"),
-				os.Getenv("AWS_SECRET_ACCESS_KEY"),
-				"",
-			)),
-		)
-	} else {
-		cfg, err = config.LoadDefaultConfig(ctx)
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			log.Fatal("Unable to print configuration:", err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+	credentials.Store(&cfg.Auth)
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatal("Unable to configure logging:", err)
+	}
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		log.Fatal("Unable to configure tracing:", err)
 	}
 
+	// The S3 service is shared with the notification worker so both use one
+	// AWS configuration
+	s3Service, err := services.NewS3Service(cfg)
 	if err != nil {
 		log.Fatal("Unable to load AWS config:", err)
 	}
+	s3Client := s3Service.Client()
 
-	s3Client := s3.NewFromConfig(cfg)
+	var notifierWorker *notifier.Worker
+	if cfg.Notifier.Enabled {
+		n, err := notifier.New(cfg, s3Service)
+		if err != nil {
+			log.Fatal("Unable to start notifier:", err)
+		}
+		defer n.Close()
+		notifierWorker = notifier.NewWorker(n, cfg.Notifier.Interval)
+		notifierWorker.Start(ctx)
+	}
 
-	http.HandleFunc("/list-bucket", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
-			return
+	exportManager, err := export.NewManager(cfg.Export, s3Service)
+	if err != nil {
+		log.Fatal("Unable to start exports:", err)
+	}
+	exportManager.Start(ctx)
+
+	trashBin := trash.New(cfg.Trash, s3Service)
+	trashBin.Start(ctx)
+
+	bulkManager := bulk.NewManager(ctx, s3Service, trashBin)
+
+	clipIndex, err := clipindex.Open(cfg.Index, s3Service)
+	if err != nil {
+		log.Fatal("Unable to open clip index:", err)
+	}
+	defer clipIndex.Close()
+
+	annotationService, err := annotations.New(cfg.Annotations, s3Service, clipIndex)
+	if err != nil {
+		log.Fatal("Unable to open annotations:", err)
+	}
+	defer annotationService.Close()
+	if err := annotationService.Start(ctx); err != nil {
+		slog.Warn("Failed to index annotations", "error", err)
+	}
+	// Started after the annotations, which read the tags of clips as they
+	// are indexed
+	clipIndex.Start(ctx)
+
+	statsCollector := stats.New(cfg.Stats, s3Service)
+
+	listingCache := listcache.New(cfg.Cache)
+	defer func() {
+		if err := listingCache.Close(); err != nil {
+			slog.Error("Failed to save listing cache", "error", err)
+		}
+	}()
+	listingCache.Start(ctx)
+	metrics.Register(listingCache)
+
+	// Listings, stats and indexed clips of the days the server changes are
+	// stale at once
+	s3Service.OnChange(listingCache.Invalidate)
+	s3Service.OnChange(statsCollector.Forget)
+	s3Service.OnChange(clipIndex.Forget)
+
+	var transcoder *hls.Transcoder
+	if cfg.HLS.Enabled {
+		transcoder, err = hls.New(cfg.HLS, s3Service)
+		if err != nil {
+			log.Fatal("Unable to start HLS transcoding:", err)
 		}
+		transcoder.Start(ctx)
+	}
+
+	certManager, err := certs.New(cfg.TLS)
+	if err != nil {
+		log.Fatal("Unable to configure TLS:", err)
+	}
+	certManager.Start(ctx)
+
+	mux := http.NewServeMux()
+
+	// Readiness covers everything the server needs to answer requests
+	readiness := health.NewChecker(cfg.Health.Timeout)
+	readiness.Add("s3", s3Service.HeadBucket)
+	readiness.Add("clip_index", clipIndex.Ping)
+	readiness.Add("annotations", annotationService.Ping)
+	readiness.Add("export_dir", health.WritableDir(cfg.Export.Dir))
+	if transcoder != nil {
+		readiness.Add("hls_dir", health.WritableDir(cfg.HLS.Dir))
+	}
+	if notifierWorker != nil {
+		readiness.Add("notifier_db", notifierWorker.Ping)
+	}
+	if certManager.Enabled() {
+		readiness.Add("tls", certManager.Check)
+	}
+
+	mux.HandleFunc("/list-bucket", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		bucketName := cfg.Storage.Bucket
 
-		result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		result, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
 			Bucket: aws.String(bucketName),
 		})
 
@@ -101,13 +241,7 @@ func main() {
 		})
 	}))
 
-	http.HandleFunc("/list-files-by-date", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
-			return
-		}
-
+	mux.HandleFunc("/list-files-by-date", basicAuth(func(w http.ResponseWriter, r *http.Request) {
 		year := r.URL.Query().Get("year")
 		month := r.URL.Query().Get("month")
 		day := r.URL.Query().Get("day")
@@ -117,51 +251,99 @@ func main() {
 			return
 		}
 
-		prefix := fmt.Sprintf("%s/%s/%s/", year, month, day)
+		filter, err := clipindex.ParseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-			Bucket: aws.String(bucketName),
-			Prefix: aws.String(prefix),
-		})
+		generation, served := listingCache.Serve(w, r)
+		if served {
+			return
+		}
 
+		prefix := fmt.Sprintf("%s/%s/%s/", year, month, day)
+		// Listings of past days are kept; today's changes as clips arrive
+		date, err := time.ParseInLocation("2006/01/02/", prefix, time.Local)
+		settled := err == nil && services.DaySettled(date, time.Now())
+
+		// The listing includes the sidecars, so the day is ingested without
+		// listing it again. It must be complete, since Sync drops the clips
+		// of the day that are missing from it.
+		objects, err := s3Service.ListAll(r.Context(), prefix)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to list objects: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		var videoKeys []string
+		for _, obj := range objects {
+			if strings.HasSuffix(obj.Key, ".mp4") {
+				videoKeys = append(videoKeys, obj.Key)
+			}
+		}
+		// The listing shows the index as it is; the day is ingested in the
+		// background, and a listing cached before it changed the index is
+		// dropped so the next one shows the new events
+		clipIndex.Refresh(prefix, objects, func() {
+			listingCache.Invalidate(prefix)
+		})
+		events, err := clipIndex.Events(r.Context(), videoKeys)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read clip events: %v", err), http.StatusInternalServerError)
+			return
+		}
+		notes, err := clipIndex.Annotations(r.Context(), videoKeys)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read clip annotations: %v", err), http.StatusInternalServerError)
+			return
+		}
+
 		var files []map[string]interface{}
-		for _, obj := range result.Contents {
-			if strings.HasSuffix(*obj.Key, ".mp4") {
+		for _, obj := range objects {
+			if strings.HasSuffix(obj.Key, ".mp4") {
+				if !filter.Match(events[obj.Key]) {
+					continue
+				}
+
 				fileInfo := map[string]interface{}{
-					"key":          *obj.Key,
-					"filename":     filepath.Base(*obj.Key),
-					"size":         *obj.Size,
+					"key":          obj.Key,
+					"filename":     filepath.Base(obj.Key),
+					"size":         obj.Size,
 					"lastModified": obj.LastModified,
 				}
 				
 				// Add storage class information
-				fileInfo["storageClass"] = string(obj.StorageClass)
+				fileInfo["storageClass"] = obj.StorageClass
+
+				if e, ok := events[obj.Key]; ok {
+					fileInfo["events"] = e
+				}
+				if a, ok := notes[obj.Key]; ok {
+					fileInfo["note"] = a.Note
+					fileInfo["tags"] = a.Tags
+				}
 				
 				files = append(files, fileInfo)
 			}
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		listingCache.Write(w, r, generation, prefix, map[string]interface{}{
 			"date":  fmt.Sprintf("%s-%s-%s", year, month, day),
 			"files": files,
 			"count": len(files),
-		})
+		}, settled)
 	}))
 
-	http.HandleFunc("/list-years", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
+	mux.HandleFunc("/list-years", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		bucketName := cfg.Storage.Bucket
+
+		generation, served := listingCache.Serve(w, r)
+		if served {
 			return
 		}
 
-		result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		result, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
 			Bucket:    aws.String(bucketName),
 			Delimiter: aws.String("/"),
 		})
@@ -173,6 +355,10 @@ func main() {
 
 		yearMap := make(map[string]bool)
 		for _, prefix := range result.CommonPrefixes {
+			// Deleted clips are kept under the trash prefix, not a year
+			if *prefix.Prefix == trashBin.Prefix() {
+				continue
+			}
 			year := strings.TrimSuffix(*prefix.Prefix, "/")
 			yearMap[year] = true
 		}
@@ -181,19 +367,16 @@ func main() {
 		for year := range yearMap {
 			years = append(years, year)
 		}
+		// Sorted so a rebuilt listing has the same body and ETag
+		sort.Strings(years)
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		listingCache.Write(w, r, generation, "", map[string]interface{}{
 			"years": years,
-		})
+		}, true)
 	}))
 
-	http.HandleFunc("/list-months", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
-			return
-		}
+	mux.HandleFunc("/list-months", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		bucketName := cfg.Storage.Bucket
 
 		year := r.URL.Query().Get("year")
 		if year == "" {
@@ -201,8 +384,13 @@ func main() {
 			return
 		}
 
+		generation, served := listingCache.Serve(w, r)
+		if served {
+			return
+		}
+
 		prefix := year + "/"
-		result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		result, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
 			Bucket:    aws.String(bucketName),
 			Prefix:    aws.String(prefix),
 			Delimiter: aws.String("/"),
@@ -224,20 +412,16 @@ func main() {
 		for month := range monthMap {
 			months = append(months, month)
 		}
+		sort.Strings(months)
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		listingCache.Write(w, r, generation, year+"/", map[string]interface{}{
 			"year":   year,
 			"months": months,
-		})
+		}, true)
 	}))
 
-	http.HandleFunc("/list-days", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
-			return
-		}
+	mux.HandleFunc("/list-days", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		bucketName := cfg.Storage.Bucket
 
 		year := r.URL.Query().Get("year")
 		month := r.URL.Query().Get("month")
@@ -246,8 +430,13 @@ func main() {
 			return
 		}
 
+		generation, served := listingCache.Serve(w, r)
+		if served {
+			return
+		}
+
 		prefix := fmt.Sprintf("%s/%s/", year, month)
-		result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		result, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
 			Bucket:    aws.String(bucketName),
 			Prefix:    aws.String(prefix),
 			Delimiter: aws.String("/"),
@@ -269,34 +458,53 @@ func main() {
 		for day := range dayMap {
 			days = append(days, day)
 		}
+		sort.Strings(days)
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		listingCache.Write(w, r, generation, prefix, map[string]interface{}{
 			"year":  year,
 			"month": month,
 			"days":  days,
-		})
+		}, true)
 	}))
 
-	http.HandleFunc("/get-video-url", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
+	mux.Handle("/metrics", basicAuth(metrics.Handler().ServeHTTP))
+
+	mux.HandleFunc("/cache", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			listingCache.Clear()
+			slog.InfoContext(r.Context(), "Cleared listing cache")
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listingCache.Stats())
+	}))
+
+	mux.HandleFunc("/get-video-url", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		bucketName := cfg.Storage.Bucket
+
 		key := r.URL.Query().Get("key")
 		if key == "" {
 			http.Error(w, "key query parameter is required", http.StatusBadRequest)
 			return
 		}
 
-		// Create a presigned URL for the video
-		presignClient := s3.NewPresignClient(s3Client)
-		request, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		input := &s3.GetObjectInput{
 			Bucket: aws.String(bucketName),
 			Key:    aws.String(key),
-		}, func(opts *s3.PresignOptions) {
+		}
+		// A version_id plays an older version of a versioned object
+		if versionID := r.URL.Query().Get("version_id"); versionID != "" {
+			input.VersionId = aws.String(versionID)
+		}
+
+		// Create a presigned URL for the video
+		presignClient := s3.NewPresignClient(s3Client)
+		request, err := presignClient.PresignGetObject(r.Context(), input, func(opts *s3.PresignOptions) {
 			opts.Expires = time.Duration(3600 * time.Second) // 1 hour expiration
 		})
 
@@ -311,13 +519,62 @@ func main() {
 		})
 	}))
 
-	http.HandleFunc("/latest-video", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
+	mux.HandleFunc("/hls", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		if transcoder == nil {
+			http.Error(w, "HLS streaming is disabled (set HLS_ENABLED=true)", http.StatusNotFound)
+			return
+		}
+
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key query parameter is required", http.StatusBadRequest)
 			return
 		}
 
+		stream, err := transcoder.Prepare(r.Context(), key)
+		switch {
+		case errors.Is(err, hls.ErrArchived):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case services.IsNotFound(err):
+			http.Error(w, "Video not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("Failed to prepare HLS stream: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stream)
+	}))
+
+	// Playlists and segments of transcoded clips; a stream's files never
+	// change, since a new version of a clip gets a new id
+	mux.HandleFunc("/hls/", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		if transcoder == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		path, err := transcoder.File(strings.TrimPrefix(r.URL.Path, "/hls/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if strings.HasSuffix(path, ".m3u8") {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		} else {
+			w.Header().Set("Content-Type", "video/mp2t")
+		}
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		streamResponse(w)
+		http.ServeFile(w, r, path)
+	}))
+
+	mux.HandleFunc("/latest-video", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		bucketName := cfg.Storage.Bucket
+
 		// Get current date in YYYY/MM/DD format
 		now := time.Now()
 		year := fmt.Sprintf("%04d", now.Year())
@@ -325,7 +582,7 @@ func main() {
 		day := fmt.Sprintf("%02d", now.Day())
 		prefix := fmt.Sprintf("%s/%s/%s/", year, month, day)
 
-		result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		result, err := s3Client.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
 			Bucket: aws.String(bucketName),
 			Prefix: aws.String(prefix),
 		})
@@ -369,13 +626,631 @@ func main() {
 		}
 	}))
 
-	http.HandleFunc("/stats", basicAuth(func(w http.ResponseWriter, r *http.Request) {
-		bucketName := os.Getenv("BUCKET_NAME")
-		if bucketName == "" {
-			http.Error(w, "BUCKET_NAME environment variable is not set", http.StatusInternalServerError)
+	mux.HandleFunc("/timeline", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		// The window is either a whole day (date=YYYY-MM-DD, today by default)
+		// or an arbitrary range given as RFC3339 start and end times
+		query := r.URL.Query()
+		var start, end time.Time
+		if query.Get("start") != "" || query.Get("end") != "" {
+			var err error
+			if start, err = time.Parse(time.RFC3339, query.Get("start")); err != nil {
+				http.Error(w, "Invalid start format (use RFC3339, e.g. 2024-01-01T08:00:00Z)", http.StatusBadRequest)
+				return
+			}
+			if end, err = time.Parse(time.RFC3339, query.Get("end")); err != nil {
+				http.Error(w, "Invalid end format (use RFC3339, e.g. 2024-01-01T20:00:00Z)", http.StatusBadRequest)
+				return
+			}
+			// Keys and filenames use the cameras' local time
+			start, end = start.In(time.Local), end.In(time.Local)
+		} else {
+			date := query.Get("date")
+			if date == "" {
+				date = time.Now().Format("2006-01-02")
+			}
+			day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+			if err != nil {
+				http.Error(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			start, end = day, day.AddDate(0, 0, 1)
+		}
+
+		if !end.After(start) {
+			http.Error(w, "end must be after start", http.StatusBadRequest)
+			return
+		}
+		if end.Sub(start) > 7*24*time.Hour {
+			http.Error(w, "Timeline window cannot be longer than 7 days", http.StatusBadRequest)
 			return
 		}
 
+		minGap := 5 * time.Second
+		if v := query.Get("min_gap"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, "Invalid min_gap (use a duration such as 30s)", http.StatusBadRequest)
+				return
+			}
+			minGap = d
+		}
+
+		filter, err := clipindex.ParseFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		timeline, err := s3Service.Timeline(r.Context(), start, end, query.Get("camera"), minGap)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build timeline: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := clipIndex.EnsureDays(r.Context(), start, end); err != nil {
+			slog.WarnContext(r.Context(), "Failed to index timeline days", "error", err)
+		}
+		keys := make([]string, 0, len(timeline.Clips))
+		for _, clip := range timeline.Clips {
+			keys = append(keys, clip.Key)
+		}
+		events, err := clipIndex.Events(r.Context(), keys)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read clip events: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Filtering drops clips but keeps the gaps, which are periods
+		// without any recording
+		clips := make([]services.Clip, 0, len(timeline.Clips))
+		for _, clip := range timeline.Clips {
+			if filter.Match(events[clip.Key]) {
+				clips = append(clips, clip)
+			} else {
+				delete(events, clip.Key)
+			}
+		}
+		timeline.Clips = clips
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			*services.Timeline
+			Events map[string]*clipindex.Events `json:"events"`
+		}{timeline, events})
+	}))
+
+	mux.HandleFunc("/search", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		query, err := clipindex.ParseQuery(r.URL.Query(), time.Local)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := clipIndex.Search(r.Context(), query)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to search clips: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}))
+
+	mux.HandleFunc("/annotations", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			key := r.URL.Query().Get("key")
+			if key == "" {
+				http.Error(w, "key query parameter is required", http.StatusBadRequest)
+				return
+			}
+
+			a, err := annotationService.Get(r.Context(), key)
+			if services.IsNotFound(err) {
+				http.Error(w, "Video not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get annotation: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"key":        key,
+				"annotation": a,
+			})
+
+		case http.MethodPut, http.MethodPost:
+			var body struct {
+				Key  string   `json:"key"`
+				Note string   `json:"note"`
+				Tags []string `json:"tags"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON body (expected {\"key\": ..., \"note\": ..., \"tags\": [...]})", http.StatusBadRequest)
+				return
+			}
+			if body.Key == "" {
+				http.Error(w, "key is required", http.StatusBadRequest)
+				return
+			}
+
+			if _, err := s3Service.StatVideo(r.Context(), body.Key); err != nil {
+				if services.IsNotFound(err) {
+					http.Error(w, "Video not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, fmt.Sprintf("Failed to get video: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			a, err := annotationService.Set(r.Context(), body.Key, annotations.Annotation{Note: body.Note, Tags: body.Tags})
+			// Day listings include the annotations
+			listingCache.Invalidate(body.Key)
+			switch {
+			case errors.Is(err, annotations.ErrInvalid):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			case services.IsNotFound(err):
+				http.Error(w, "Video not found", http.StatusNotFound)
+				return
+			case err != nil:
+				http.Error(w, fmt.Sprintf("Failed to save annotation: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"key":        body.Key,
+				"annotation": a,
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.HandleFunc("/exports", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if id == "" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"exports": exportManager.List(),
+				})
+				return
+			}
+			job, err := exportManager.Get(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(job)
+		case http.MethodPost:
+			var req export.Request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body (expected {\"camera\", \"start\", \"end\", \"format\"})", http.StatusBadRequest)
+				return
+			}
+			job, err := exportManager.Create(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(job)
+		case http.MethodDelete:
+			if err := exportManager.Delete(id); err != nil {
+				if errors.Is(err, export.ErrNotFound) {
+					http.Error(w, err.Error(), http.StatusNotFound)
+				} else {
+					http.Error(w, fmt.Sprintf("Failed to delete export: %v", err), http.StatusInternalServerError)
+				}
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.HandleFunc("/exports/download", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		f, name, err := exportManager.Open(r.URL.Query().Get("id"))
+		switch {
+		case errors.Is(err, export.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, export.ErrNotReady):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("Failed to open export: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to open export: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		streamResponse(w)
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	}))
+
+	mux.HandleFunc("/download-zip", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		// The selection is either a date prefix or a list of keys, given as
+		// query parameters or as a JSON body
+		var selection struct {
+			Date   string   `json:"date"`
+			Prefix string   `json:"prefix"`
+			Keys   []string `json:"keys"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			selection.Date = query.Get("date")
+			selection.Prefix = query.Get("prefix")
+			selection.Keys = query["key"]
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&selection); err != nil {
+				http.Error(w, "Invalid JSON body (expected {\"date\"}, {\"prefix\"} or {\"keys\": [...]})", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if selection.Date != "" {
+			day, err := time.Parse("2006-01-02", selection.Date)
+			if err != nil {
+				http.Error(w, "Invalid date format (use YYYY-MM-DD)", http.StatusBadRequest)
+				return
+			}
+			selection.Prefix = day.Format("2006/01/02/")
+		}
+
+		var videos []services.Video
+		archiveName := "camera-viewer-selection.zip"
+		switch {
+		case selection.Prefix != "" && len(selection.Keys) > 0:
+			http.Error(w, "Use either a date/prefix or keys, not both", http.StatusBadRequest)
+			return
+		case selection.Prefix != "":
+			// A prefix within a day keeps the listing small; a prefix such
+			// as "2" would list whole years before the size check
+			if _, ok := services.DayOf(selection.Prefix, time.Local); !ok {
+				http.Error(w, "prefix must be within a day, such as 2024/01/15/", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if videos, err = s3Service.ListVideos(r.Context(), selection.Prefix); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list objects: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(videos) > cfg.Export.MaxClips {
+				http.Error(w, fmt.Sprintf("Too many videos (the limit is %d)", cfg.Export.MaxClips), http.StatusBadRequest)
+				return
+			}
+			archiveName = "camera-viewer-" + strings.ReplaceAll(strings.Trim(selection.Prefix, "/"), "/", "-") + ".zip"
+		case len(selection.Keys) > 0:
+			if len(selection.Keys) > cfg.Export.MaxClips {
+				http.Error(w, fmt.Sprintf("Too many keys (the limit is %d)", cfg.Export.MaxClips), http.StatusBadRequest)
+				return
+			}
+			seen := make(map[string]bool)
+			for _, key := range selection.Keys {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				video, err := s3Service.StatVideo(r.Context(), key)
+				switch {
+				case services.IsNotFound(err):
+					http.Error(w, fmt.Sprintf("Video not found: %s", key), http.StatusNotFound)
+					return
+				case r.Context().Err() != nil:
+					// The client is gone
+					return
+				case err != nil:
+					slog.ErrorContext(r.Context(), "Failed to read video for ZIP download", "key", key, "error", err)
+					http.Error(w, "Failed to read the selected videos", http.StatusBadGateway)
+					return
+				}
+				videos = append(videos, video)
+			}
+		default:
+			http.Error(w, "date, prefix or key parameters are required", http.StatusBadRequest)
+			return
+		}
+
+		manifest := &export.Manifest{
+			Bucket:    s3Service.BucketName(),
+			Prefix:    selection.Prefix,
+			CreatedAt: time.Now().UTC(),
+			Clips:     []export.ManifestClip{},
+		}
+		var clips []services.Clip
+		var total int64
+		for _, video := range videos {
+			clip := services.NewClip(video, time.Local)
+			if !clip.Playable {
+				manifest.Skipped = append(manifest.Skipped, export.SkippedClip{
+					Key:    clip.Key,
+					Reason: fmt.Sprintf("stored in %s, restore it first", clip.StorageClass),
+				})
+				continue
+			}
+			clips = append(clips, clip)
+			total += clip.Size
+		}
+		if len(clips) == 0 {
+			http.Error(w, "No downloadable videos in the selection", http.StatusNotFound)
+			return
+		}
+		// The size is checked up front since an error cannot be reported once
+		// the archive has started streaming
+		if total > cfg.Export.MaxZIPBytes {
+			http.Error(w, fmt.Sprintf("Selection is %d bytes, the limit is %d bytes", total, cfg.Export.MaxZIPBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		sort.Slice(clips, func(i, j int) bool {
+			return clips[i].Start.Before(clips[j].Start)
+		})
+		manifest.Start = clips[0].Start
+		manifest.End = clips[len(clips)-1].End
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName))
+		streamResponse(w)
+		if err := export.WriteZIP(r.Context(), w, s3Service, clips, manifest, nil); err != nil {
+			slog.ErrorContext(r.Context(), "ZIP download failed", "videos", len(clips), "error", err)
+		}
+	}))
+
+	mux.HandleFunc("/bulk", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"jobs": bulkManager.List(),
+				})
+				return
+			}
+			job, err := bulkManager.Get(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(job)
+		case http.MethodPost:
+			var req bulk.Request
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON body (expected {\"action\", \"selection\", \"destination\", \"dry_run\"})", http.StatusBadRequest)
+				return
+			}
+
+			// A dry run answers immediately with everything the operation
+			// would touch
+			if req.DryRun {
+				plan, err := bulkManager.Plan(r.Context(), req)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"dry_run": true,
+					"plan":    plan,
+				})
+				return
+			}
+
+			job, err := bulkManager.Start(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(job)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.HandleFunc("/trash", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Keys []string `json:"keys"`
+		}
+		keys := r.URL.Query()["key"]
+		if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+			if len(keys) == 0 {
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					http.Error(w, "Invalid JSON body (expected {\"keys\": [...]})", http.StatusBadRequest)
+					return
+				}
+				keys = body.Keys
+			}
+			if len(keys) == 0 {
+				http.Error(w, "keys are required", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			items, err := trashBin.List(r.Context())
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list trash: %v", err), http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"items":     items,
+				"count":     len(items),
+				"retention": cfg.Trash.Retention.String(),
+			})
+		case http.MethodPost:
+			// Soft delete: clips are moved to the trash and can be restored
+			trashed, failed := trashBin.Delete(r.Context(), keys)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"trashed":  trashed,
+				"failures": failed,
+			})
+		case http.MethodDelete:
+			// Permanently delete clips that are already in the trash
+			removed, failed, err := trashBin.Remove(r.Context(), keys)
+			if err != nil && len(removed) == 0 {
+				http.Error(w, fmt.Sprintf("Failed to empty trash: %v", err), http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"removed":  removed,
+				"failures": failed,
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	mux.HandleFunc("/trash/restore", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		keys := r.URL.Query()["key"]
+		if len(keys) == 0 {
+			var body struct {
+				Keys []string `json:"keys"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid JSON body (expected {\"keys\": [...]})", http.StatusBadRequest)
+				return
+			}
+			keys = body.Keys
+		}
+		if len(keys) == 0 {
+			http.Error(w, "keys are required", http.StatusBadRequest)
+			return
+		}
+
+		restored := []string{}
+		failed := []services.KeyError{}
+		for _, key := range keys {
+			if err := trashBin.Restore(r.Context(), key); err != nil {
+				failed = append(failed, services.KeyError{Key: key, Error: err.Error()})
+				continue
+			}
+			restored = append(restored, key)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"restored": restored,
+			"failures": failed,
+		})
+	}))
+
+	mux.HandleFunc("/versions", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		// Listings stop after this many versions, so a busy day does not
+		// page through the bucket's whole history
+		const maxVersions = 1000
+
+		key := r.URL.Query().Get("key")
+		prefix := r.URL.Query().Get("prefix")
+		if (key == "") == (prefix == "") {
+			http.Error(w, "exactly one of key or prefix is required", http.StatusBadRequest)
+			return
+		}
+		if _, ok := services.DayOf(prefix, time.Local); prefix != "" && !ok {
+			http.Error(w, "prefix must be within a day, such as 2024/01/15/", http.StatusBadRequest)
+			return
+		}
+
+		status, err := s3Service.VersioningStatus(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get versioning status: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		listPrefix := prefix
+		if key != "" {
+			listPrefix = key
+		}
+		versions, truncated, err := s3Service.ListVersions(r.Context(), listPrefix, maxVersions)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list versions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Listing by key also matches longer keys sharing it as a prefix
+		result := []services.ObjectVersion{}
+		for _, v := range versions {
+			if key == "" || v.Key == key {
+				result = append(result, v)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"versioning": status,
+			"versions":   result,
+			"truncated":  truncated,
+		})
+	}))
+
+	mux.HandleFunc("/versions/restore", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Key       string `json:"key"`
+			VersionID string `json:"version_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body (expected {\"key\": ..., \"version_id\": ...})", http.StatusBadRequest)
+			return
+		}
+		if body.Key == "" || body.VersionID == "" {
+			http.Error(w, "key and version_id are required", http.StatusBadRequest)
+			return
+		}
+
+		err := s3Service.RestoreVersion(r.Context(), body.Key, body.VersionID)
+		switch {
+		case errors.Is(err, services.ErrVersionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, services.ErrDeleteMarker):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("Failed to restore version: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "Restored version", "key", body.Key, "version_id", body.VersionID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"restored":   body.Key,
+			"version_id": body.VersionID,
+		})
+	}))
+
+	mux.HandleFunc("/stats", basicAuth(func(w http.ResponseWriter, r *http.Request) {
 		// Get date range from query parameters
 		startDate := r.URL.Query().Get("start_date")
 		endDate := r.URL.Query().Get("end_date")
@@ -400,59 +1275,51 @@ func main() {
 			return
 		}
 
+		// Every day is listed, so a range is at most a year
+		const maxStatsDays = 366
+		if endTime.Before(startTime) {
+			http.Error(w, "end_date must not be before start_date", http.StatusBadRequest)
+			return
+		}
+		if endTime.After(startTime.AddDate(0, 0, maxStatsDays-1)) {
+			http.Error(w, fmt.Sprintf("The range must be at most %d days", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+
+		days, err := statsCollector.Collect(r.Context(), startTime, endTime)
+		switch {
+		case r.Context().Err() != nil:
+			// The client is gone; 499 is what proxies log for this
+			slog.InfoContext(r.Context(), "Stopped collecting stats", "error", err)
+			w.WriteHeader(499)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to collect stats", "error", err)
+			http.Error(w, "Failed to collect stats", http.StatusInternalServerError)
+			return
+		}
+
 		// Collect statistics
 		dailyStats := make(map[string]map[string]interface{})
 		totalVideos := 0
 		var totalSize int64 = 0
 		storageClassCounts := make(map[string]int)
-		
-		// Iterate through each day in the range
-		for d := startTime; !d.After(endTime); d = d.AddDate(0, 0, 1) {
-			dateStr := d.Format("2006-01-02")
-			year := fmt.Sprintf("%04d", d.Year())
-			month := fmt.Sprintf("%02d", d.Month())
-			day := fmt.Sprintf("%02d", d.Day())
-			prefix := fmt.Sprintf("%s/%s/%s/", year, month, day)
-
-			result, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-				Bucket: aws.String(bucketName),
-				Prefix: aws.String(prefix),
-			})
 
-			if err != nil {
-				// Log error but continue processing other dates
-				log.Printf("Error listing objects for %s: %v", dateStr, err)
+		for dateStr, day := range days {
+			// Only include days with videos
+			if day.Videos == 0 {
 				continue
 			}
-
-			dayVideos := 0
-			var daySize int64 = 0
-			dayStorageClasses := make(map[string]int)
-
-			for _, obj := range result.Contents {
-				if strings.HasSuffix(*obj.Key, ".mp4") {
-					dayVideos++
-					totalVideos++
-					daySize += *obj.Size
-					totalSize += *obj.Size
-					
-					storageClass := string(obj.StorageClass)
-					if storageClass == "" {
-						storageClass = "STANDARD"
-					}
-					dayStorageClasses[storageClass]++
-					storageClassCounts[storageClass]++
-				}
+			totalVideos += day.Videos
+			totalSize += day.SizeBytes
+			for storageClass, count := range day.StorageClasses {
+				storageClassCounts[storageClass] += count
 			}
-
-			// Only include days with videos
-			if dayVideos > 0 {
-				dailyStats[dateStr] = map[string]interface{}{
-					"videos": dayVideos,
-					"size_bytes": daySize,
-					"size_mb": float64(daySize) / (1024 * 1024),
-					"storage_classes": dayStorageClasses,
-				}
+			dailyStats[dateStr] = map[string]interface{}{
+				"videos": day.Videos,
+				"size_bytes": day.SizeBytes,
+				"size_mb": float64(day.SizeBytes) / (1024 * 1024),
+				"storage_classes": day.StorageClasses,
 			}
 		}
 
@@ -481,50 +1348,344 @@ func main() {
 		})
 	}))
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/notifier/away", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		// Away mode is shared with the discord notifier through a JSON file on a
+		// common volume; while away, notification schedules are ignored
+		awayFile := cfg.Notifier.AwayFile
+		if awayFile == "" {
+			http.Error(w, "NOTIFIER_AWAY_FILE environment variable is not set", http.StatusInternalServerError)
+			return
+		}
+
+		var state struct {
+			Away  bool       `json:"away"`
+			Until *time.Time `json:"until,omitempty"`
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			data, err := os.ReadFile(awayFile)
+			if err != nil && !os.IsNotExist(err) {
+				http.Error(w, fmt.Sprintf("Failed to read away mode: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if len(data) > 0 {
+				if err := json.Unmarshal(data, &state); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to read away mode: %v", err), http.StatusInternalServerError)
+					return
+				}
+			}
+		case http.MethodPost, http.MethodPut:
+			if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+				http.Error(w, "Invalid JSON body (expected {\"away\": true, \"until\": \"RFC3339 time\"})", http.StatusBadRequest)
+				return
+			}
+			if err := writeJSONFile(awayFile, state); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save away mode: %v", err), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodDelete:
+			if err := writeJSONFile(awayFile, state); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save away mode: %v", err), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		active := state.Away && (state.Until == nil || time.Now().Before(*state.Until))
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
+			"away":   state.Away,
+			"until":  state.Until,
+			"active": active,
+		})
+	}))
+
+	mux.HandleFunc("/admin/notifier", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+		if notifierWorker == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"enabled": false,
+			})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			// Check for new videos now instead of waiting for the next interval
+			notifierWorker.RunNow()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled": true,
+			"status":  notifierWorker.Status(),
+		})
+	}))
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{
 			"status": "healthy",
 			"timestamp": time.Now().UTC().Format(time.RFC3339),
 			"service": "camera-viewer",
+		}
+		if notifierWorker != nil {
+			status := notifierWorker.Status()
+			body["notifier"] = map[string]interface{}{
+				"last_run":   status.LastRun,
+				"last_error": status.LastError,
+				"counts":     status.Counts,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})
+
+	// Liveness only says the process is serving; it does not touch
+	// dependencies, so an S3 outage does not get the container restarted
+	mux.HandleFunc("/health/live", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "alive",
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
 		})
 	})
 
-	http.HandleFunc("/video", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		report := readiness.Run(r.Context())
+		if !report.Ready() {
+			attrs := []any{}
+			for name, check := range report.Checks {
+				if check.Status != health.StatusOK {
+					attrs = append(attrs, name, check.Error)
+				}
+			}
+			slog.WarnContext(r.Context(), "Not ready", attrs...)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !report.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+
+	mux.HandleFunc("/video", basicAuth(func(w http.ResponseWriter, r *http.Request) {
 		// Handle deep linking to specific videos
 		// Example: /video?key=2024/01/01/video.mp4
 		http.ServeFile(w, r, "index.html")
 	}))
 
-	http.HandleFunc("/", basicAuth(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", basicAuth(func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "index.html")
 	}))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	port := cfg.Server.Port
+
+	baseURL := "http://localhost:" + port
+	if certManager.Enabled() {
+		baseURL = "https://localhost:" + port
+	}
+	slog.Info("Server starting", "port", port, "tls", certManager.Enabled(), "redirect_port", cfg.TLS.RedirectPort)
+	if cfg.Tracing.Enabled {
+		slog.Info("Tracing enabled", "endpoint", cfg.Tracing.Endpoint, "sample_ratio", cfg.Tracing.SampleRatio)
 	}
 
-	fmt.Printf("Server starting on port %s...\n", port)
-	
-	username := os.Getenv("USERNAME")
-	password := os.Getenv("PASSWORD")
-	if username != "" && password != "" {
-		fmt.Println("Authentication enabled - USERNAME and PASSWORD required")
+	if cfg.Auth.Username != "" {
+		slog.Info("Authentication enabled - USERNAME and PASSWORD required")
 	} else {
-		fmt.Println("Warning: No authentication configured (set USERNAME and PASSWORD env vars)")
-	}
-	
-	fmt.Println("Available endpoints:")
-	fmt.Printf("  - http://localhost:%s/ (Web UI)\n", port)
-	fmt.Printf("  - http://localhost:%s/list-bucket\n", port)
-	fmt.Printf("  - http://localhost:%s/list-years\n", port)
-	fmt.Printf("  - http://localhost:%s/list-months?year=2024\n", port)
-	fmt.Printf("  - http://localhost:%s/list-days?year=2024&month=01\n", port)
-	fmt.Printf("  - http://localhost:%s/list-files-by-date?year=2024&month=01&day=15\n", port)
-	fmt.Printf("  - http://localhost:%s/stats (with optional start_date and end_date params)\n", port)
-
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal("Server failed to start:", err)
-	}
-}
\ No newline at end of file
+		slog.Warn("No authentication configured (set USERNAME and PASSWORD env vars)")
+	}
+	if notifierWorker != nil {
+		slog.Info("Notification worker enabled", "interval", cfg.Notifier.Interval)
+	}
+
+	// The endpoint list is for people reading the console, not log parsers
+	if cfg.Log.Format == logging.FormatText {
+		fmt.Println("Available endpoints:")
+		fmt.Printf("  - %s/ (Web UI)\n", baseURL)
+		fmt.Printf("  - %s/list-bucket\n", baseURL)
+		fmt.Printf("  - %s/list-years\n", baseURL)
+		fmt.Printf("  - %s/list-months?year=2024\n", baseURL)
+		fmt.Printf("  - %s/list-days?year=2024&month=01\n", baseURL)
+		fmt.Printf("  - %s/list-files-by-date?year=2024&month=01&day=15 (optional label, zone, min_confidence)\n", baseURL)
+		fmt.Printf("  - %s/metrics (Prometheus)\n", baseURL)
+		fmt.Printf("  - %s/health/live and /health/ready (no auth)\n", baseURL)
+		fmt.Printf("  - %s/cache (GET listing cache stats, DELETE to clear)\n", baseURL)
+		if transcoder != nil {
+			fmt.Printf("  - %s/hls?key=... (HLS stream status, transcodes on first request)\n", baseURL)
+		}
+		fmt.Printf("  - %s/timeline?date=2024-01-15 (or start/end RFC3339 times, optional camera)\n", baseURL)
+		fmt.Printf("  - %s/search?q=person&start=2024-01-01 (clip search with facets)\n", baseURL)
+		fmt.Printf("  - %s/annotations?key=... (GET, or PUT {\"key\", \"note\", \"tags\"})\n", baseURL)
+		fmt.Printf("  - %s/exports (GET list or ?id=, POST to create, DELETE ?id=)\n", baseURL)
+		fmt.Printf("  - %s/exports/download?id=...\n", baseURL)
+		fmt.Printf("  - %s/download-zip?date=2024-01-15 (or prefix, or key=... repeated)\n", baseURL)
+		fmt.Printf("  - %s/bulk (POST delete/copy/move with optional dry_run, GET progress)\n", baseURL)
+		fmt.Printf("  - %s/trash (GET list, POST to delete, DELETE to purge) and /trash/restore\n", baseURL)
+		fmt.Printf("  - %s/versions?key=... (or prefix) and POST /versions/restore\n", baseURL)
+		fmt.Printf("  - %s/stats (with optional start_date and end_date params)\n", baseURL)
+		fmt.Printf("  - %s/notifier/away (GET, POST {\"away\": true}, DELETE)\n", baseURL)
+		if notifierWorker != nil {
+			fmt.Printf("  - %s/admin/notifier (GET status, POST to run now)\n", baseURL)
+		}
+	}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           tracing.Middleware(mux, logging.Middleware(mux, certManager.HSTS(metrics.Middleware(mux)))),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	// The redirect server answers plain HTTP, and ACME HTTP-01 challenges,
+	// on a second port
+	var redirectServer *http.Server
+	if certManager.Enabled() {
+		server.TLSConfig = certManager.TLSConfig()
+		if cfg.TLS.RedirectPort != "" {
+			redirectServer = &http.Server{
+				Addr:              ":" + cfg.TLS.RedirectPort,
+				Handler:           certManager.RedirectHandler(port),
+				ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+				ReadTimeout:       cfg.Server.ReadTimeout,
+				WriteTimeout:      cfg.Server.WriteTimeout,
+				IdleTimeout:       cfg.Server.IdleTimeout,
+			}
+		}
+	}
+
+	go reloadOnHangup(*configFile, *cfg)
+
+	shutdown, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 2)
+	go func() {
+		if server.TLSConfig != nil {
+			// Certificates come from TLSConfig rather than file arguments
+			serveErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- server.ListenAndServe()
+	}()
+	if redirectServer != nil {
+		go func() {
+			serveErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Server failed", "error", err)
+		failed = true
+	case <-shutdown.Done():
+	}
+	// A second signal stops the server without waiting
+	stop()
+
+	// Shutdown stops accepting connections and waits for in-flight requests,
+	// downloads included, to finish
+	slog.Info("Shutting down, waiting for requests to finish", "timeout", cfg.Server.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelDrain()
+	if redirectServer != nil {
+		redirectServer.Shutdown(drainCtx)
+	}
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Warn("Requests still running, closing their connections", "error", err)
+		server.Close()
+	}
+
+	// Wait for the background work to stop before the deferred calls close
+	// the databases it writes to
+	cancel()
+	if notifierWorker != nil {
+		notifierWorker.Wait()
+	}
+	exportManager.Wait()
+	bulkManager.Wait()
+	trashBin.Wait()
+	clipIndex.Wait()
+	if transcoder != nil {
+		transcoder.Wait()
+	}
+	listingCache.Wait()
+
+	// Spans of the last requests are still queued for the collector
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// reloadOnHangup reloads the configuration on SIGHUP. The credentials and
+// the log level and access log take effect at once; other changed settings
+// are logged as needing a restart. running is the configuration in effect.
+func reloadOnHangup(configFile string, running config.Config) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		next, err := config.LoadFile(configFile)
+		if err != nil {
+			slog.Error("Configuration not reloaded", "error", err)
+			continue
+		}
+
+		var restart []string
+		changed := running.Changed(next)
+		for _, key := range changed {
+			switch key {
+			case "auth.username", "auth.password", "log.level", "log.access":
+			default:
+				restart = append(restart, key)
+			}
+		}
+		if err := logging.Reload(next.Log); err != nil {
+			slog.Error("Configuration not reloaded", "error", err)
+			continue
+		}
+		running.Log.Level, running.Log.Access = next.Log.Level, next.Log.Access
+		running.Auth = next.Auth
+		credentials.Store(&next.Auth)
+
+		slog.Info("Reloaded configuration", "changed", changed)
+		if len(restart) > 0 {
+			slog.Warn("Changed settings take effect after a restart", "settings", restart)
+		}
+	}
+}
+
+// streamResponse lifts the server's write timeout for a response that may
+// take much longer, such as footage streamed to a slow client
+func streamResponse(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.Warn("Failed to lift write timeout", "error", err)
+	}
+}
+
+// writeJSONFile replaces path with the JSON encoding of v, writing to a
+// temporary file first so readers never see a partial file
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
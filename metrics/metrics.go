@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"camera-viewer/response"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "camera_viewer"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to answer HTTP requests by route and method.",
+		Buckets:   []float64{0.005, 0.025, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 120},
+	}, []string{"route", "method"})
+
+	httpResponseBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Bytes sent in HTTP response bodies by route, including footage proxied from S3.",
+	}, []string{"route"})
+
+	authFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected for missing or wrong credentials.",
+	})
+
+	storageClips = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_clips",
+		Help:      "Clips by camera and storage class in the range of the last /stats request.",
+	}, []string{"camera", "storage_class"})
+
+	storageBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_bytes",
+		Help:      "Bytes of clips by camera and storage class in the range of the last /stats request.",
+	}, []string{"camera", "storage_class"})
+
+	storageUpdated = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "storage_last_computed_timestamp_seconds",
+		Help:      "When the storage gauges were last computed.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Register adds a collector, such as a cache reporting its own counters
+func Register(c prometheus.Collector) {
+	prometheus.MustRegister(c)
+}
+
+// AuthFailure counts a request rejected by basic auth
+func AuthFailure() {
+	authFailures.Inc()
+}
+
+// StorageUsage is the storage one camera uses in one storage class
+type StorageUsage struct {
+	Camera       string
+	StorageClass string
+	Clips        int
+	Bytes        int64
+}
+
+// SetStorage replaces the storage gauges
+func SetStorage(usage []StorageUsage) {
+	storageClips.Reset()
+	storageBytes.Reset()
+	for _, u := range usage {
+		storageClips.WithLabelValues(u.Camera, u.StorageClass).Set(float64(u.Clips))
+		storageBytes.WithLabelValues(u.Camera, u.StorageClass).Set(float64(u.Bytes))
+	}
+	storageUpdated.SetToCurrentTime()
+}
+
+// Middleware records every request mux serves under the pattern it
+// matched, so that routes are labelled without their query or key
+func Middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete:
+		default:
+			method = "other"
+		}
+
+		rec := response.NewRecorder(w)
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+
+		httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(rec.Status)).Inc()
+		httpResponseBytes.WithLabelValues(route).Add(float64(rec.Bytes))
+	})
+}
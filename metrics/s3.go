@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	s3Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_requests_total",
+		Help:      "S3 API calls by operation, counting each retry.",
+	}, []string{"operation"})
+
+	s3Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_errors_total",
+		Help:      "Failed S3 API calls by operation and error code.",
+	}, []string{"operation", "code"})
+
+	s3Duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_request_duration_seconds",
+		Help:      "Latency of S3 API calls by operation, until the response headers are read.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+// AWSMiddleware records the S3 calls of a client; add it to the client's
+// APIOptions. It wraps the deserialize step, so it sees S3's error codes,
+// and presigning URLs, which never sends a request, is not counted.
+func AWSMiddleware(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("CameraViewerMetrics",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleDeserialize(ctx, in)
+
+			operation := awsmiddleware.GetOperationName(ctx)
+			s3Requests.WithLabelValues(operation).Inc()
+			s3Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+			if err != nil {
+				s3Errors.WithLabelValues(operation, errorCode(err)).Inc()
+			}
+			return out, md, err
+		}), middleware.Before)
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.ErrorCode()
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "DeadlineExceeded"
+	default:
+		return "Other"
+	}
+}
@@ -0,0 +1,238 @@
+package notifier
+
+import (
+	"camera-viewer/services"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Notification modes selectable through NOTIFY_MODE
+const (
+	modeImmediate = "immediate"
+	modeBatch     = "batch"
+	modeHourly    = "hourly"
+	modeDaily     = "daily"
+
+	// modeMorning summarizes clips suppressed by a schedule
+	modeMorning = "morning"
+)
+
+// Discord caps embed descriptions at 4096 characters and embeds at 25 fields
+const (
+	maxDescriptionLength = 4000
+	maxDigestCameras     = 20
+)
+
+type pendingVideo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// videoGroup is a set of videos sharing one notification window
+type videoGroup struct {
+	Start  time.Time
+	End    time.Time
+	Videos []pendingVideo
+}
+
+// windowStart returns the start of the notification window containing t.
+// Daily windows follow local calendar days rather than UTC.
+func windowStart(t time.Time, mode string, batchWindow time.Duration) time.Time {
+	switch mode {
+	case modeHourly:
+		return t.Truncate(time.Hour)
+	case modeDaily:
+		t = t.Local()
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	default:
+		return t.Truncate(batchWindow)
+	}
+}
+
+func windowEnd(start time.Time, mode string, batchWindow time.Duration) time.Time {
+	switch mode {
+	case modeHourly:
+		return start.Add(time.Hour)
+	case modeDaily:
+		return start.AddDate(0, 0, 1)
+	default:
+		return start.Add(batchWindow)
+	}
+}
+
+// groupVideos buckets videos into windows and returns only the windows that
+// have already closed. Videos in a window that is still open stay unposted
+// and are picked up again on the next run.
+func groupVideos(videos []pendingVideo, mode string, batchWindow time.Duration, now time.Time) []videoGroup {
+	groups := make(map[time.Time]*videoGroup)
+	for _, v := range videos {
+		start := windowStart(v.LastModified, mode, batchWindow)
+		g, ok := groups[start]
+		if !ok {
+			g = &videoGroup{Start: start, End: windowEnd(start, mode, batchWindow)}
+			groups[start] = g
+		}
+		g.Videos = append(g.Videos, v)
+	}
+
+	var closed []videoGroup
+	for _, g := range groups {
+		if g.End.After(now) {
+			continue
+		}
+		sort.Slice(g.Videos, func(i, j int) bool {
+			return g.Videos[i].Key < g.Videos[j].Key
+		})
+		closed = append(closed, *g)
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		return closed[i].Start.Before(closed[j].Start)
+	})
+	return closed
+}
+
+// dateFromKey returns the YYYY-MM-DD date encoded in a video key
+func dateFromKey(key string) string {
+	if len(key) > 10 && key[4] == '/' && key[7] == '/' {
+		return fmt.Sprintf("%s-%s-%s", key[0:4], key[5:7], key[8:10])
+	}
+	return "Unknown"
+}
+
+func videoLink(cameraViewerURL, key string) string {
+	return fmt.Sprintf("%s/video?key=%s", strings.TrimRight(cameraViewerURL, "/"), key)
+}
+
+func dayLink(cameraViewerURL, date string) string {
+	return fmt.Sprintf("%s/?date=%s", strings.TrimRight(cameraViewerURL, "/"), date)
+}
+
+// buildBatchMessage lists every video in the group in a single embed
+func buildBatchMessage(group videoGroup, bucketName, cameraViewerURL string) DiscordWebhookMessage {
+	var totalSize int64
+	var lines []string
+	for _, v := range group.Videos {
+		totalSize += v.Size
+		line := fmt.Sprintf("• `%s` %s (%s)", v.LastModified.Local().Format("15:04:05"), path.Base(v.Key), formatFileSize(v.Size))
+		if cameraViewerURL != "" {
+			line += fmt.Sprintf(" — [Watch](%s)", videoLink(cameraViewerURL, v.Key))
+		}
+		lines = append(lines, line)
+	}
+
+	description := fmt.Sprintf("New videos uploaded to S3 bucket `%s`:\n", bucketName)
+	for i, line := range lines {
+		if len(description)+len(line)+1 > maxDescriptionLength {
+			description += fmt.Sprintf("…and %d more", len(lines)-i)
+			break
+		}
+		description += line + "\n"
+	}
+
+	embed := DiscordEmbed{
+		Title:       fmt.Sprintf("📹 %d New Videos Uploaded", len(group.Videos)),
+		Description: description,
+		Color:       0x00ff00,
+		Fields: []DiscordField{
+			{
+				Name:   "🕒 Window",
+				Value:  fmt.Sprintf("%s – %s", group.Start.Local().Format("2006-01-02 15:04"), group.End.Local().Format("15:04")),
+				Inline: true,
+			},
+			{
+				Name:   "📊 Total Size",
+				Value:  formatFileSize(totalSize),
+				Inline: true,
+			},
+		},
+		Timestamp: group.End.Format(time.RFC3339),
+		Footer: &DiscordFooter{
+			Text: "Camera Viewer S3 Monitor",
+		},
+	}
+
+	return DiscordWebhookMessage{Embeds: []DiscordEmbed{embed}}
+}
+
+// buildDigestMessage summarizes the group as per-camera counts with links to
+// the day view of every date covered
+func buildDigestMessage(group videoGroup, mode, bucketName, cameraViewerURL string) DiscordWebhookMessage {
+	counts := make(map[string]int)
+	sizes := make(map[string]int64)
+	dates := make(map[string]bool)
+	var totalSize int64
+	for _, v := range group.Videos {
+		camera := services.CameraFromKey(v.Key)
+		counts[camera]++
+		sizes[camera] += v.Size
+		totalSize += v.Size
+		dates[dateFromKey(v.Key)] = true
+	}
+
+	cameras := make([]string, 0, len(counts))
+	for camera := range counts {
+		cameras = append(cameras, camera)
+	}
+	sort.Strings(cameras)
+
+	var fields []DiscordField
+	for i, camera := range cameras {
+		if i == maxDigestCameras {
+			fields = append(fields, DiscordField{
+				Name:  "➕ Other cameras",
+				Value: fmt.Sprintf("%d more", len(cameras)-i),
+			})
+			break
+		}
+		fields = append(fields, DiscordField{
+			Name:   fmt.Sprintf("🎥 %s", camera),
+			Value:  fmt.Sprintf("%d video(s), %s", counts[camera], formatFileSize(sizes[camera])),
+			Inline: true,
+		})
+	}
+
+	if cameraViewerURL != "" {
+		var links []string
+		for date := range dates {
+			if date != "Unknown" {
+				links = append(links, fmt.Sprintf("[%s](%s)", date, dayLink(cameraViewerURL, date)))
+			}
+		}
+		sort.Strings(links)
+		if len(links) > 0 {
+			fields = append(fields, DiscordField{
+				Name:  "🔗 View Day",
+				Value: strings.Join(links, " · "),
+			})
+		}
+	}
+
+	title := "📊 Hourly Video Digest"
+	period := fmt.Sprintf("%s – %s", group.Start.Local().Format("2006-01-02 15:04"), group.End.Local().Format("15:04"))
+	switch mode {
+	case modeDaily:
+		title = "📊 Daily Video Digest"
+		period = group.Start.Local().Format("Monday, 2006-01-02")
+	case modeMorning:
+		title = "🌅 Morning Digest"
+		period = fmt.Sprintf("quiet hours (%s – %s)", group.Start.Local().Format("Jan 2 15:04"), group.End.Local().Format("Jan 2 15:04"))
+	}
+
+	embed := DiscordEmbed{
+		Title:       title,
+		Description: fmt.Sprintf("%d video(s) totalling %s uploaded to `%s` during %s", len(group.Videos), formatFileSize(totalSize), bucketName, period),
+		Color:       0x3498db,
+		Fields:      fields,
+		Timestamp:   group.End.Format(time.RFC3339),
+		Footer: &DiscordFooter{
+			Text: "Camera Viewer S3 Monitor",
+		},
+	}
+
+	return DiscordWebhookMessage{Embeds: []DiscordEmbed{embed}}
+}
@@ -0,0 +1,255 @@
+package notifier
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: discord-notifier [command]
+
+Without a command, checks S3 for new videos and delivers notifications.
+
+Commands:
+  status                      Show schema version and entry counts
+  list [-status S] [-limit N] List tracked videos, newest first
+  outbox [-limit N]           List queued and abandoned notifications
+  reset [-status S] [key...]  Forget videos so they are notified again
+  retry                       Requeue abandoned notifications
+  migrate                     Apply database migrations and exit
+`
+
+// RunCommand runs a maintenance subcommand and returns the exit code
+func RunCommand(dbPath string, args []string) int {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(usage)
+		return 0
+	}
+
+	db, err := initDatabase(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		err = cmdStatus(db)
+	case "list":
+		err = cmdList(db, args[1:])
+	case "outbox":
+		err = cmdOutbox(db, args[1:])
+	case "reset":
+		err = cmdReset(db, args[1:])
+	case "retry":
+		err = cmdRetry(db)
+	case "migrate":
+		var version int
+		version, err = schemaVersion(db)
+		if err == nil {
+			fmt.Printf("Database is at schema version %d\n", version)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func cmdStatus(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version: %d\n\nVideos:\n", version)
+
+	rows, err := db.Query("SELECT status, COUNT(*) FROM posted_videos GROUP BY status ORDER BY status")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return err
+		}
+		fmt.Printf("  %-10s %d\n", status, count)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var queued, failing int
+	if err := db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN last_error IS NULL THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN last_error IS NOT NULL THEN 1 ELSE 0 END), 0)
+		FROM outbox`).Scan(&queued, &failing); err != nil {
+		return err
+	}
+	var undigested int
+	if err := db.QueryRow("SELECT COUNT(*) FROM suppressed_videos WHERE digested = 0").Scan(&undigested); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nOutbox:\n  queued     %d\n  failing    %d\n\nSuppressed awaiting digest: %d\n", queued, failing, undigested)
+	return nil
+}
+
+func cmdList(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	status := fs.String("status", "", "only show videos with this status")
+	limit := fs.Int("limit", 50, "maximum number of videos to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := "SELECT s3_key, status, posted_at, COALESCE(updated_at, posted_at) FROM posted_videos"
+	var queryArgs []interface{}
+	if *status != "" {
+		query += " WHERE status = ?"
+		queryArgs = append(queryArgs, *status)
+	}
+	query += " ORDER BY posted_at DESC, id DESC LIMIT ?"
+	queryArgs = append(queryArgs, *limit)
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTATUS\tCLAIMED\tUPDATED")
+	for rows.Next() {
+		var key, status, postedAt, updatedAt string
+		if err := rows.Scan(&key, &status, &postedAt, &updatedAt); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, status, postedAt, updatedAt)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+func cmdOutbox(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	limit := fs.Int("limit", 50, "maximum number of entries to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, channel, attempts, next_attempt_at, COALESCE(last_error, ''), s3_keys
+		FROM outbox ORDER BY id LIMIT ?`, *limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCHANNEL\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR\tKEYS")
+	for rows.Next() {
+		var id int64
+		var attempts int
+		var channel, nextAttempt, lastError, keys string
+		if err := rows.Scan(&id, &channel, &attempts, &nextAttempt, &lastError, &keys); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\n", id, channel, attempts, nextAttempt, lastError, keys)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// cmdReset deletes tracked videos by key or status. Videos still inside the
+// lookback window are notified again on the next run.
+func cmdReset(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	status := fs.String("status", "", "reset every video with this status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	keys := fs.Args()
+	if *status == "" && len(keys) == 0 {
+		return fmt.Errorf("reset needs -status or at least one key")
+	}
+
+	var total int64
+	if *status != "" {
+		result, err := db.Exec("DELETE FROM posted_videos WHERE status = ?", *status)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+	for _, key := range keys {
+		result, err := db.Exec("DELETE FROM posted_videos WHERE s3_key = ?", strings.TrimSpace(key))
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+
+	fmt.Printf("Reset %d video(s)\n", total)
+	return nil
+}
+
+// cmdRetry makes every failed outbox entry due again and moves its videos
+// back to pending
+func cmdRetry(db *sql.DB) error {
+	entries, err := db.Query("SELECT s3_keys FROM outbox WHERE last_error IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	var keyLists []string
+	for entries.Next() {
+		var keys string
+		if err := entries.Scan(&keys); err != nil {
+			entries.Close()
+			return err
+		}
+		keyLists = append(keyLists, keys)
+	}
+	entries.Close()
+	if err := entries.Err(); err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`
+		UPDATE outbox SET attempts = 0, last_error = NULL, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE last_error IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	for _, keys := range keyLists {
+		var parsed []string
+		if err := json.Unmarshal([]byte(keys), &parsed); err != nil {
+			continue
+		}
+		if err := setVideoStatus(db, parsed, statusPending, statusFailed); err != nil {
+			return err
+		}
+	}
+
+	n, _ := result.RowsAffected()
+	fmt.Printf("Requeued %d notification(s)\n", n)
+	return nil
+}
@@ -0,0 +1,207 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRateLimitWait bounds how long a single run will sleep for a rate limit.
+// Longer waits are left to the outbox so cron runs never overlap.
+const maxRateLimitWait = 30 * time.Second
+
+// errRateLimited is returned when Discord asks us to back off for longer than
+// maxRateLimitWait
+var errRateLimited = errors.New("discord rate limit exceeds maximum wait")
+
+// maxRateLimitHits is how many 429s a single message may receive before they
+// start counting as failed attempts
+const maxRateLimitHits = 5
+
+// permanentError marks a webhook failure that will not succeed on retry, such
+// as a deleted webhook or a rejected payload
+type permanentError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("discord webhook returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// rateLimitResponse is the JSON body Discord sends with a 429
+type rateLimitResponse struct {
+	Message    string  `json:"message"`
+	RetryAfter float64 `json:"retry_after"`
+	Global     bool    `json:"global"`
+}
+
+// discordClient posts webhook messages while honouring Discord's rate limit
+// headers and retrying transient failures
+type discordClient struct {
+	webhookURL string
+	httpClient *http.Client
+	maxRetries int
+
+	// nextAllowed is the earliest time the next request may be sent, set when
+	// the current bucket is exhausted or Discord returns a 429
+	nextAllowed time.Time
+}
+
+func newDiscordClient(webhookURL string, maxRetries int) *discordClient {
+	return &discordClient{
+		webhookURL: webhookURL,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		maxRetries: maxRetries,
+	}
+}
+
+// Send delivers a message, waiting out rate limits and retrying network
+// errors and 5xx responses with exponential backoff. It gives up with ctx's
+// error when ctx is done, waits included.
+func (c *discordClient) Send(ctx context.Context, message DiscordWebhookMessage) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return &permanentError{Body: fmt.Sprintf("failed to marshal discord message: %v", err)}
+	}
+
+	backoff := time.Second
+	rateLimited := 0
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if err := c.waitForSlot(ctx); err != nil {
+			return err
+		}
+
+		resp, err := c.post(ctx, jsonData)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			slog.WarnContext(ctx, "Discord request failed", "attempt", attempt+1, "max_attempts", c.maxRetries+1, "error", err)
+			if err := sleep(ctx, backoff); err != nil {
+				return err
+			}
+			backoff *= 2
+			continue
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		c.trackBucket(resp.Header)
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait, global := retryAfter(resp.Header, body)
+			slog.WarnContext(ctx, "Discord rate limited", "global", global, "retry_after", wait.String())
+			c.nextAllowed = time.Now().Add(wait)
+			lastErr = fmt.Errorf("discord webhook returned status %d", resp.StatusCode)
+			// A 429 is not a failure of the message itself, so it does not use
+			// up a retry unless Discord keeps refusing us
+			if rateLimited++; rateLimited <= maxRateLimitHits {
+				attempt--
+			}
+
+		case resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("discord webhook returned status %d", resp.StatusCode)
+			slog.WarnContext(ctx, "Discord server error", "attempt", attempt+1, "max_attempts", c.maxRetries+1, "error", lastErr)
+			if err := sleep(ctx, backoff); err != nil {
+				return err
+			}
+			backoff *= 2
+
+		default:
+			return &permanentError{StatusCode: resp.StatusCode, Body: string(body)}
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", c.maxRetries+1, lastErr)
+}
+
+func (c *discordClient) post(ctx context.Context, jsonData []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.webhookURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send discord notification: %w", err)
+	}
+	return resp, nil
+}
+
+// waitForSlot sleeps until the rate limit allows another request
+func (c *discordClient) waitForSlot(ctx context.Context) error {
+	wait := time.Until(c.nextAllowed)
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return fmt.Errorf("%w (%s)", errRateLimited, wait.Round(time.Second))
+	}
+	return sleep(ctx, wait)
+}
+
+// sleep waits for d, returning early with ctx's error when ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// trackBucket records when the per-webhook bucket resets once it has no
+// requests remaining
+func (c *discordClient) trackBucket(header http.Header) {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+	if next := time.Now().Add(secondsToDuration(resetAfter)); next.After(c.nextAllowed) {
+		c.nextAllowed = next
+	}
+}
+
+// retryAfter reads the wait from a 429 response, preferring the precise body
+// value over the Retry-After header
+func retryAfter(header http.Header, body []byte) (time.Duration, bool) {
+	var rl rateLimitResponse
+	global := header.Get("X-RateLimit-Global") == "true" || header.Get("X-RateLimit-Scope") == "global"
+	if err := json.Unmarshal(body, &rl); err == nil && rl.RetryAfter > 0 {
+		return secondsToDuration(rl.RetryAfter), global || rl.Global
+	}
+	if seconds, err := strconv.ParseFloat(header.Get("Retry-After"), 64); err == nil {
+		return secondsToDuration(seconds), global
+	}
+	if resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+		return secondsToDuration(resetAfter), global
+	}
+	return 5 * time.Second, global
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,105 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+)
+
+type DiscordWebhookMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []DiscordEmbed `json:"embeds,omitempty"`
+}
+
+type DiscordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color,omitempty"`
+	Fields      []DiscordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	Footer      *DiscordFooter `json:"footer,omitempty"`
+}
+
+type DiscordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type DiscordFooter struct {
+	Text string `json:"text"`
+}
+
+func buildVideoMessage(bucketName, videoKey string, fileSize int64, lastModified time.Time, cameraViewerURL string) DiscordWebhookMessage {
+	// Extract date and filename from the key (format: YYYY/MM/DD/filename.mp4)
+	date := dateFromKey(videoKey)
+	filename := videoKey
+	if date != "Unknown" && len(videoKey) > 11 {
+		filename = videoKey[11:]
+	}
+
+	// Format file size
+	sizeStr := formatFileSize(fileSize)
+
+	// Create fields for the embed
+	fields := []DiscordField{
+		{
+			Name:   "📅 Date",
+			Value:  date,
+			Inline: true,
+		},
+		{
+			Name:   "📁 Filename",
+			Value:  filename,
+			Inline: true,
+		},
+		{
+			Name:   "📊 Size",
+			Value:  sizeStr,
+			Inline: true,
+		},
+		{
+			Name:   "🗂️ S3 Key",
+			Value:  fmt.Sprintf("`%s`", videoKey),
+			Inline: false,
+		},
+	}
+
+	// Add video link if camera viewer URL is configured
+	if cameraViewerURL != "" {
+		videoURL := videoLink(cameraViewerURL, videoKey)
+		fields = append(fields, DiscordField{
+			Name:   "🔗 Watch Video",
+			Value:  fmt.Sprintf("[Click here to watch](%s)", videoURL),
+			Inline: false,
+		})
+	}
+
+	// Create embed message
+	embed := DiscordEmbed{
+		Title:       "📹 New Video Uploaded",
+		Description: fmt.Sprintf("A new video has been uploaded to S3 bucket `%s`", bucketName),
+		Color:       0x00ff00, // Green color
+		Fields:      fields,
+		Timestamp:   lastModified.Format(time.RFC3339),
+		Footer: &DiscordFooter{
+			Text: "Camera Viewer S3 Monitor",
+		},
+	}
+
+	return DiscordWebhookMessage{
+		Embeds: []DiscordEmbed{embed},
+	}
+}
+
+func formatFileSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,290 @@
+package notifier
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Notifier checks the bucket for new videos and posts them to Discord. One
+// call to Run is one check; the standalone binary runs it once from cron and
+// the server's Worker runs it on an interval.
+type Notifier struct {
+	cfg        config.NotifierConfig
+	bucketName string
+	s3Service  *services.S3Service
+	settings   *notifierSettings
+	db         *sql.DB
+	clients    map[string]*discordClient
+}
+
+// RunResult summarizes one check
+type RunResult struct {
+	NewVideos int `json:"new_videos"`
+	Digested  int `json:"digested"`
+	Delivered int `json:"delivered"`
+}
+
+// Counts reports what is waiting in the notifier database
+type Counts struct {
+	PendingVideos       int `json:"pending_videos"`
+	FailedVideos        int `json:"failed_videos"`
+	QueuedNotifications int `json:"queued_notifications"`
+	FailingDeliveries   int `json:"failing_deliveries"`
+}
+
+// New creates a notifier from a configuration that has passed Validate
+func New(cfg *config.Config, s3Service *services.S3Service) (*Notifier, error) {
+	n := cfg.Notifier
+	settings, err := loadSettings(n.ScheduleFile, n.DiscordWebhookURL, cfg.Cameras)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification settings: %w", err)
+	}
+
+	db, err := initDatabase(n.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	clients := make(map[string]*discordClient)
+	for _, ch := range settings.Channels {
+		clients[ch.Name] = newDiscordClient(ch.WebhookURL, n.MaxRetries)
+	}
+
+	return &Notifier{
+		cfg:        n,
+		bucketName: cfg.Storage.Bucket,
+		s3Service:  s3Service,
+		settings:   settings,
+		db:         db,
+		clients:    clients,
+	}, nil
+}
+
+func (n *Notifier) Close() error {
+	return n.db.Close()
+}
+
+// Ping checks that the notifier database can be read
+func (n *Notifier) Ping(ctx context.Context) error {
+	var count int
+	return n.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM posted_videos").Scan(&count)
+}
+
+// Run checks the lookback window for new videos, queues their notifications
+// and delivers everything due in the outbox
+func (n *Notifier) Run(ctx context.Context) (RunResult, error) {
+	var result RunResult
+
+	slog.DebugContext(ctx, "Checking for new videos")
+	now := time.Now()
+
+	// Videos claimed by a run that died before queueing them are picked up
+	// again below. The standalone binary may have no interval set.
+	stale := n.cfg.Interval
+	if stale < time.Minute {
+		stale = time.Minute
+	}
+	if count, err := reclaimStalePending(n.db, stale); err != nil {
+		slog.ErrorContext(ctx, "Failed to reclaim stale pending videos", "error", err)
+	} else if count > 0 {
+		slog.WarnContext(ctx, "Reclaimed videos that were claimed but never queued", "count", count)
+	}
+
+	var pending []pendingVideo
+	var listErr error
+	for i := 0; i < n.cfg.LookbackDays; i++ {
+		date := now.AddDate(0, 0, -i)
+		prefix := fmt.Sprintf("%04d/%02d/%02d/", date.Year(), date.Month(), date.Day())
+
+		videos, err := n.s3Service.ListVideos(ctx, prefix)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list videos", "prefix", prefix, "error", err)
+			listErr = fmt.Errorf("listing %s: %w", prefix, err)
+			continue
+		}
+
+		for _, video := range videos {
+			// Check if we've already posted about this video
+			posted, err := isVideoPosted(n.db, video.Key)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to check if video was posted", "key", video.Key, "error", err)
+				continue
+			}
+
+			if !posted {
+				lastModified := video.LastModified
+				if lastModified.IsZero() {
+					lastModified = now
+				}
+
+				pending = append(pending, pendingVideo{
+					Key:          video.Key,
+					Size:         video.Size,
+					LastModified: lastModified,
+				})
+			}
+		}
+	}
+
+	// In batch and digest modes only videos whose window has closed are handled
+	ready := pending
+	if n.cfg.Mode != modeImmediate {
+		ready = nil
+		for _, group := range groupVideos(pending, n.cfg.Mode, n.cfg.BatchWindow, now) {
+			ready = append(ready, group.Videos...)
+		}
+	}
+
+	// Claim before queueing so overlapping runs never notify a video twice
+	claimed, err := claimVideos(n.db, ready)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to claim videos", "error", err)
+	}
+
+	away := loadAwayState(n.cfg.AwayFile).active(now)
+	if away {
+		slog.InfoContext(ctx, "Away mode is on, ignoring notification schedules")
+	}
+
+	queuedKeys := make(map[string]bool)
+	failedKeys := make(map[string]bool)
+	for _, ch := range n.settings.Channels {
+		active, suppressed := n.settings.split(ch, claimed, away)
+
+		if err := recordSuppressed(n.db, ch.Name, suppressed); err != nil {
+			slog.ErrorContext(ctx, "Failed to record suppressed videos", "channel", ch.Name, "error", err)
+		} else if len(suppressed) > 0 {
+			slog.InfoContext(ctx, "Suppressed videos by schedule", "channel", ch.Name, "count", len(suppressed))
+		}
+
+		var queued, failed []string
+		if n.cfg.Mode == modeImmediate {
+			queued, failed = n.enqueueEach(ch.Name, active)
+		} else {
+			queued, failed = n.enqueueGroups(ch.Name, groupVideos(active, n.cfg.Mode, n.cfg.BatchWindow, now))
+		}
+		for _, key := range queued {
+			queuedKeys[key] = true
+		}
+		for _, key := range failed {
+			failedKeys[key] = true
+		}
+	}
+
+	// Videos with a queued notification stay pending until it is delivered
+	var failedList, quietList []string
+	for _, v := range claimed {
+		switch {
+		case queuedKeys[v.Key]:
+		case failedKeys[v.Key]:
+			failedList = append(failedList, v.Key)
+		default:
+			quietList = append(quietList, v.Key)
+		}
+	}
+	if err := setVideoStatus(n.db, failedList, statusFailed, statusPending); err != nil {
+		slog.ErrorContext(ctx, "Failed to mark videos as failed", "error", err)
+	}
+	if err := setVideoStatus(n.db, quietList, statusSuppressed, statusPending); err != nil {
+		slog.ErrorContext(ctx, "Failed to mark videos as suppressed", "error", err)
+	}
+
+	result.NewVideos = len(claimed)
+	if result.NewVideos == 0 {
+		slog.DebugContext(ctx, "No new videos found")
+	} else {
+		slog.InfoContext(ctx, "Found new videos", "count", result.NewVideos)
+	}
+
+	result.Digested = enqueueMorningDigests(n.db, n.settings, now, n.bucketName, n.cfg.CameraViewerURL)
+	if result.Digested > 0 {
+		slog.InfoContext(ctx, "Queued morning digest", "videos", result.Digested)
+	}
+
+	// Deliver queued notifications, including ones that failed on earlier runs
+	delivered, deliverErr := deliverOutbox(ctx, n.db, n.clients, n.cfg.MaxAttempts)
+	if deliverErr != nil {
+		slog.ErrorContext(ctx, "Stopped delivering notifications", "error", deliverErr)
+	}
+	result.Delivered = delivered
+	if delivered > 0 {
+		slog.InfoContext(ctx, "Delivered notifications", "count", delivered)
+	}
+
+	// Clean up entries past the retention period
+	if err := cleanupOldEntries(n.db, time.Duration(n.cfg.RetentionDays)*24*time.Hour); err != nil {
+		slog.ErrorContext(ctx, "Failed to clean up old entries", "error", err)
+	}
+
+	if listErr != nil {
+		return result, listErr
+	}
+	return result, deliverErr
+}
+
+// Counts reads the pending and failed totals from the database
+func (n *Notifier) Counts() (Counts, error) {
+	var c Counts
+	err := n.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = ? THEN 1 ELSE 0 END), 0)
+		FROM posted_videos`, statusPending, statusFailed).Scan(&c.PendingVideos, &c.FailedVideos)
+	if err != nil {
+		return c, err
+	}
+	err = n.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN last_error IS NULL THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN last_error IS NOT NULL THEN 1 ELSE 0 END), 0)
+		FROM outbox`).Scan(&c.QueuedNotifications, &c.FailingDeliveries)
+	return c, err
+}
+
+// enqueueEach queues one notification per video, returning the keys that
+// were queued and the keys that could not be
+func (n *Notifier) enqueueEach(channel string, videos []pendingVideo) (queued, failed []string) {
+	for _, v := range videos {
+		message := buildVideoMessage(n.bucketName, v.Key, v.Size, v.LastModified, n.cfg.CameraViewerURL)
+		if err := enqueueNotification(n.db, channel, message, []string{v.Key}); err != nil {
+			slog.Error("Failed to queue Discord notification", "key", v.Key, "error", err)
+			failed = append(failed, v.Key)
+			continue
+		}
+		queued = append(queued, v.Key)
+	}
+	return queued, failed
+}
+
+// enqueueGroups queues one batch or digest message per closed window,
+// returning the keys that were queued and the keys that could not be
+func (n *Notifier) enqueueGroups(channel string, groups []videoGroup) (queued, failed []string) {
+	for _, group := range groups {
+		var message DiscordWebhookMessage
+		if n.cfg.Mode == modeBatch {
+			message = buildBatchMessage(group, n.bucketName, n.cfg.CameraViewerURL)
+		} else {
+			message = buildDigestMessage(group, n.cfg.Mode, n.bucketName, n.cfg.CameraViewerURL)
+		}
+
+		keys := make([]string, 0, len(group.Videos))
+		for _, v := range group.Videos {
+			keys = append(keys, v.Key)
+		}
+
+		if err := enqueueNotification(n.db, channel, message, keys); err != nil {
+			slog.Error("Failed to queue Discord notification", "mode", n.cfg.Mode, "window_start", group.Start.Format(time.RFC3339), "error", err)
+			failed = append(failed, keys...)
+			continue
+		}
+		queued = append(queued, keys...)
+	}
+	return queued, failed
+}
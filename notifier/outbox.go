@@ -0,0 +1,174 @@
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// outboxSQL creates the table holding notifications that have been built but
+// not yet delivered. Rows are deleted once Discord accepts them.
+const outboxSQL = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel TEXT NOT NULL DEFAULT 'default',
+	payload TEXT NOT NULL,
+	s3_keys TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_next_attempt ON outbox(next_attempt_at);
+`
+
+type outboxEntry struct {
+	ID       int64
+	Channel  string
+	Payload  string
+	Keys     []string
+	Attempts int
+}
+
+// enqueueNotification stores a message in the outbox. Its videos must already
+// be claimed; the message survives failures until it is delivered.
+func enqueueNotification(db *sql.DB, channel string, message DiscordWebhookMessage, keys []string) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal discord message: %w", err)
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to marshal s3 keys: %w", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO outbox (channel, payload, s3_keys) VALUES (?, ?, ?)", channel, string(payload), string(keysJSON)); err != nil {
+		return fmt.Errorf("failed to insert outbox entry: %w", err)
+	}
+	return nil
+}
+
+// deliverOutbox sends every due outbox entry in order and returns how many were
+// delivered. Failed entries are rescheduled with exponential backoff until
+// maxAttempts is reached, after which they are kept for inspection but no
+// longer retried. Delivery stops when ctx is done, leaving the entry being
+// sent and the rest for the next run.
+func deliverOutbox(ctx context.Context, db *sql.DB, clients map[string]*discordClient, maxAttempts int) (int, error) {
+	entries, err := dueOutboxEntries(db, maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	limited := make(map[string]bool)
+	var limitErr error
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		if limited[entry.Channel] {
+			continue
+		}
+
+		var message DiscordWebhookMessage
+		if err := json.Unmarshal([]byte(entry.Payload), &message); err != nil {
+			slog.Error("Dropping unreadable outbox entry", "entry", entry.ID, "error", err)
+			if _, err := db.Exec("DELETE FROM outbox WHERE id = ?", entry.ID); err != nil {
+				slog.Error("Failed to delete outbox entry", "entry", entry.ID, "error", err)
+			}
+			continue
+		}
+
+		client, ok := clients[entry.Channel]
+		if !ok {
+			slog.Warn("Skipping outbox entry for unknown channel", "entry", entry.ID, "channel", entry.Channel)
+			continue
+		}
+
+		sendErr := client.Send(ctx, message)
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		if sendErr == nil {
+			if _, err := db.Exec("DELETE FROM outbox WHERE id = ?", entry.ID); err != nil {
+				slog.Error("Failed to delete delivered outbox entry", "entry", entry.ID, "error", err)
+			}
+			if err := setVideoStatus(db, entry.Keys, statusSent, statusPending); err != nil {
+				slog.Error("Failed to mark videos as sent", "error", err)
+			}
+			slog.Info("Delivered notification", "channel", entry.Channel, "videos", len(entry.Keys))
+			delivered++
+			continue
+		}
+
+		if errors.Is(sendErr, errRateLimited) {
+			// Leave the remaining entries for this channel to the next run
+			limited[entry.Channel] = true
+			if limitErr == nil {
+				limitErr = fmt.Errorf("channel %s: %w", entry.Channel, sendErr)
+			}
+			continue
+		}
+
+		attempts := entry.Attempts + 1
+		var permanent *permanentError
+		if errors.As(sendErr, &permanent) {
+			attempts = maxAttempts
+		}
+		if err := rescheduleOutboxEntry(db, entry.ID, attempts, sendErr); err != nil {
+			slog.Error("Failed to reschedule outbox entry", "entry", entry.ID, "error", err)
+		}
+		if attempts >= maxAttempts {
+			if err := setVideoStatus(db, entry.Keys, statusFailed, statusPending); err != nil {
+				slog.Error("Failed to mark videos as failed", "error", err)
+			}
+			slog.Error("Giving up on notification", "keys", entry.Keys, "attempts", attempts, "error", sendErr)
+		} else {
+			slog.Warn("Failed to deliver notification", "keys", entry.Keys, "attempt", attempts, "max_attempts", maxAttempts, "error", sendErr)
+		}
+	}
+
+	return delivered, limitErr
+}
+
+func dueOutboxEntries(db *sql.DB, maxAttempts int) ([]outboxEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, channel, payload, s3_keys, attempts FROM outbox
+		WHERE attempts < ? AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY id`, maxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []outboxEntry
+	for rows.Next() {
+		var entry outboxEntry
+		var keysJSON string
+		if err := rows.Scan(&entry.ID, &entry.Channel, &entry.Payload, &keysJSON, &entry.Attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(keysJSON), &entry.Keys); err != nil {
+			slog.Error("Outbox entry has unreadable keys", "entry", entry.ID, "error", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// rescheduleOutboxEntry records a failed attempt and pushes the next attempt
+// back, doubling from one minute up to 6 hours
+func rescheduleOutboxEntry(db *sql.DB, id int64, attempts int, sendErr error) error {
+	delay := time.Minute << uint(attempts-1)
+	if delay > 6*time.Hour || delay <= 0 {
+		delay = 6 * time.Hour
+	}
+	_, err := db.Exec(`
+		UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = datetime('now', ?)
+		WHERE id = ?`, attempts, sendErr.Error(), fmt.Sprintf("+%d seconds", int(delay.Seconds())), id)
+	return err
+}
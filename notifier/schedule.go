@@ -0,0 +1,251 @@
+package notifier
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // the alpine runtime image ships without zoneinfo
+)
+
+const defaultChannel = "default"
+
+// channelConfig is a Discord channel. Clips recorded outside its schedule,
+// or their camera's, are suppressed.
+type channelConfig struct {
+	Name       string              `json:"name"`
+	WebhookURL string              `json:"webhook_url"`
+	Cameras    []string            `json:"cameras,omitempty"`
+	Schedule   config.CameraConfig `json:"schedule"`
+}
+
+// notifierSettings is loaded from NOTIFIER_SCHEDULE_FILE. Without a file a
+// single unrestricted channel posts to DISCORD_WEBHOOK_URL.
+type notifierSettings struct {
+	Timezone      string                         `json:"timezone,omitempty"`
+	MorningDigest string                         `json:"morning_digest,omitempty"`
+	Channels      []channelConfig                `json:"channels,omitempty"`
+	Cameras       map[string]config.CameraConfig `json:"cameras,omitempty"`
+
+	location *time.Location
+}
+
+// awayState is written by the camera viewer's /notifier/away endpoint
+type awayState struct {
+	Away  bool      `json:"away"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// loadSettings reads the schedule file, if any, and adds the schedules of
+// the cameras in the config file, which replace the file's for a camera
+func loadSettings(path, webhookURL string, cameras map[string]config.CameraConfig) (*notifierSettings, error) {
+	settings := &notifierSettings{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schedule file: %w", err)
+		}
+		if err := json.Unmarshal(data, settings); err != nil {
+			return nil, fmt.Errorf("failed to parse schedule file: %w", err)
+		}
+	}
+	for camera, c := range cameras {
+		if settings.Cameras == nil {
+			settings.Cameras = make(map[string]config.CameraConfig)
+		}
+		settings.Cameras[camera] = c
+	}
+
+	if len(settings.Channels) == 0 {
+		if webhookURL == "" {
+			return nil, fmt.Errorf("DISCORD_WEBHOOK_URL environment variable is required")
+		}
+		settings.Channels = []channelConfig{{Name: defaultChannel, WebhookURL: webhookURL}}
+	}
+
+	settings.location = time.Local
+	if settings.Timezone != "" {
+		loc, err := time.LoadLocation(settings.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", settings.Timezone, err)
+		}
+		settings.location = loc
+	}
+
+	if settings.MorningDigest != "" {
+		if _, err := parseClock(settings.MorningDigest); err != nil {
+			return nil, fmt.Errorf("invalid morning_digest: %w", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, ch := range settings.Channels {
+		if ch.Name == "" || ch.WebhookURL == "" {
+			return nil, fmt.Errorf("channel %d needs a name and webhook_url", i+1)
+		}
+		if names[ch.Name] {
+			return nil, fmt.Errorf("duplicate channel name %q", ch.Name)
+		}
+		names[ch.Name] = true
+		if err := validateSchedule(ch.Schedule); err != nil {
+			return nil, fmt.Errorf("channel %q: %w", ch.Name, err)
+		}
+	}
+	for camera, s := range settings.Cameras {
+		if err := validateSchedule(s); err != nil {
+			return nil, fmt.Errorf("camera %q: %w", camera, err)
+		}
+	}
+
+	return settings, nil
+}
+
+// split divides videos covered by a channel into those to notify about now and
+// those suppressed by the camera or channel schedule. Away mode overrides all
+// schedules.
+func (s *notifierSettings) split(ch channelConfig, videos []pendingVideo, away bool) (active, suppressed []pendingVideo) {
+	for _, v := range videos {
+		camera := services.CameraFromKey(v.Key)
+		if len(ch.Cameras) > 0 && !contains(ch.Cameras, camera) {
+			continue
+		}
+
+		t := v.LastModified.In(s.location)
+		allowed := away || (allows(ch.Schedule, t) && allows(s.Cameras[camera], t))
+		if allowed {
+			active = append(active, v)
+		} else {
+			suppressed = append(suppressed, v)
+		}
+	}
+	return active, suppressed
+}
+
+// morningDigestTime returns today's morning digest time, or the zero time
+// when the morning digest is disabled
+func (s *notifierSettings) morningDigestTime(now time.Time) time.Time {
+	if s.MorningDigest == "" {
+		return time.Time{}
+	}
+	minutes, _ := parseClock(s.MorningDigest)
+	now = now.In(s.location)
+	return time.Date(now.Year(), now.Month(), now.Day(), minutes/60, minutes%60, 0, 0, s.location)
+}
+
+// validateSchedule checks a schedule from the schedule file
+func validateSchedule(s config.CameraConfig) error {
+	for _, r := range s.QuietHours {
+		if _, _, err := parseClockRange(r); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.Days {
+		if _, ok := dayNames[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("unknown day %q", d)
+		}
+	}
+	return nil
+}
+
+// allows reports whether schedule s lets a clip recorded at t be notified
+// about. t must already be in the configured timezone.
+func allows(s config.CameraConfig, t time.Time) bool {
+	if s.WeekdaysOnly && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return false
+	}
+	if len(s.Days) > 0 {
+		allowed := false
+		for _, d := range s.Days {
+			if dayNames[strings.ToLower(d)] == t.Weekday() {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, r := range s.QuietHours {
+		start, end, err := parseClockRange(r)
+		if err != nil {
+			continue
+		}
+		if start <= end && minute >= start && minute < end {
+			return false
+		}
+		if start > end && (minute >= start || minute < end) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseClockRange parses "HH:MM-HH:MM" into minutes since midnight
+func parseClockRange(r string) (int, int, error) {
+	parts := strings.Split(r, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid quiet hours %q (use HH:MM-HH:MM)", r)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use HH:MM)", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// loadAwayState reads the away mode file, treating a missing file as home
+func loadAwayState(path string) awayState {
+	var state awayState
+	if path == "" {
+		return state
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return awayState{}
+	}
+	return state
+}
+
+func (a awayState) active(now time.Time) bool {
+	return a.Away && (a.Until.IsZero() || now.Before(a.Until))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
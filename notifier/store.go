@@ -0,0 +1,245 @@
+package notifier
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Status of a video in posted_videos. A video is claimed as pending before
+// its notification is queued, so overlapping runs never notify it twice.
+const (
+	statusPending    = "pending"
+	statusSent       = "sent"
+	statusFailed     = "failed"
+	statusSuppressed = "suppressed"
+)
+
+// migration upgrades the database schema by one version
+type migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// migrations are applied in order and must never be edited once released;
+// add a new version instead
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "posted videos, outbox and suppressed videos",
+		Up: func(tx *sql.Tx) error {
+			// Databases created before versioning already have some of these
+			// tables, so every statement tolerates existing objects
+			baseSQL := `
+			CREATE TABLE IF NOT EXISTS posted_videos (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				s3_key TEXT UNIQUE NOT NULL,
+				posted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_posted_at ON posted_videos(posted_at);
+			` + outboxSQL + suppressedSQL
+			if _, err := tx.Exec(baseSQL); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "outbox", "channel", "TEXT NOT NULL DEFAULT 'default'")
+		},
+	},
+	{
+		Version:     2,
+		Description: "delivery status on posted videos",
+		Up: func(tx *sql.Tx) error {
+			// Rows written before statuses existed were only inserted once a
+			// notification had been sent or queued
+			_, err := tx.Exec(`
+			ALTER TABLE posted_videos ADD COLUMN status TEXT NOT NULL DEFAULT 'sent';
+			ALTER TABLE posted_videos ADD COLUMN updated_at TIMESTAMP;
+			UPDATE posted_videos SET updated_at = posted_at;
+			CREATE INDEX IF NOT EXISTS idx_posted_status ON posted_videos(status);
+			`)
+			return err
+		},
+	},
+}
+
+func initDatabase(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrate applies every migration newer than the recorded schema version,
+// each in its own transaction
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.Up(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, description) VALUES (?, ?)", m.Version, m.Description); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+		}
+		slog.Info("Applied database migration", "version", m.Version, "description", m.Description)
+	}
+
+	return nil
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func addColumnIfMissing(db execer, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func isVideoPosted(db *sql.DB, s3Key string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM posted_videos WHERE s3_key = ?", s3Key).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// claimVideos atomically marks videos as pending and returns the ones this
+// run claimed. A video already claimed by another run is skipped.
+func claimVideos(db *sql.DB, videos []pendingVideo) ([]pendingVideo, error) {
+	var claimed []pendingVideo
+	for _, v := range videos {
+		result, err := db.Exec(
+			"INSERT OR IGNORE INTO posted_videos (s3_key, status, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+			v.Key, statusPending,
+		)
+		if err != nil {
+			return claimed, fmt.Errorf("failed to claim %s: %w", v.Key, err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			claimed = append(claimed, v)
+		}
+	}
+	return claimed, nil
+}
+
+// setVideoStatus moves videos to a new status. When from is non-empty only
+// videos currently in that status are changed.
+func setVideoStatus(db execer, keys []string, status, from string) error {
+	for _, key := range keys {
+		query := "UPDATE posted_videos SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE s3_key = ?"
+		args := []interface{}{status, key}
+		if from != "" {
+			query += " AND status = ?"
+			args = append(args, from)
+		}
+		if _, err := db.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to set status of %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// reclaimStalePending forgets videos claimed as pending more than age ago
+// that no outbox entry covers, so the next scan claims them again. Such a
+// video was claimed by a run that stopped before queueing it; claiming and
+// queueing happen within a run, well inside the run interval.
+func reclaimStalePending(db *sql.DB, age time.Duration) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM posted_videos
+		WHERE status = ? AND updated_at < datetime('now', ?)
+		AND s3_key NOT IN (SELECT key.value FROM outbox, json_each(outbox.s3_keys) AS key)`,
+		statusPending, fmt.Sprintf("-%d seconds", int(age.Seconds())),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// cleanupOldEntries deletes finished entries older than the retention period.
+// Failed videos are kept until they are reset through the CLI, and pending
+// ones until they are delivered, given up on or reclaimed.
+func cleanupOldEntries(db *sql.DB, retention time.Duration) error {
+	cutoff := fmt.Sprintf("-%d seconds", int(retention.Seconds()))
+	if _, err := db.Exec(
+		"DELETE FROM posted_videos WHERE status IN (?, ?) AND posted_at < datetime('now', ?)",
+		statusSent, statusSuppressed, cutoff,
+	); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM suppressed_videos WHERE digested = 1 AND suppressed_at < datetime('now', ?)", cutoff); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// suppressedSQL creates the table of clips a channel's schedule kept quiet.
+// They are still claimed in posted_videos so they are never notified late.
+const suppressedSQL = `
+CREATE TABLE IF NOT EXISTS suppressed_videos (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel TEXT NOT NULL,
+	s3_key TEXT NOT NULL,
+	size INTEGER NOT NULL DEFAULT 0,
+	last_modified TIMESTAMP NOT NULL,
+	digested INTEGER NOT NULL DEFAULT 0,
+	suppressed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(channel, s3_key)
+);
+CREATE INDEX IF NOT EXISTS idx_suppressed_pending ON suppressed_videos(channel, digested);
+`
+
+// recordSuppressed stores clips suppressed for a channel
+func recordSuppressed(db *sql.DB, channel string, videos []pendingVideo) error {
+	if len(videos) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, v := range videos {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO suppressed_videos (channel, s3_key, size, last_modified) VALUES (?, ?, ?, ?)",
+			channel, v.Key, v.Size, v.LastModified.UTC(),
+		); err != nil {
+			return fmt.Errorf("failed to record suppressed video: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// enqueueMorningDigests queues, once the morning digest time has passed, a
+// digest per channel of the clips suppressed before it. Returns how many
+// clips were included.
+func enqueueMorningDigests(db *sql.DB, settings *notifierSettings, now time.Time, bucketName, cameraViewerURL string) int {
+	morning := settings.morningDigestTime(now)
+	if morning.IsZero() || now.Before(morning) {
+		return 0
+	}
+
+	included := 0
+	for _, ch := range settings.Channels {
+		ids, videos, err := undigestedVideos(db, ch.Name, morning)
+		if err != nil {
+			slog.Error("Failed to load suppressed videos", "channel", ch.Name, "error", err)
+			continue
+		}
+		if len(videos) == 0 {
+			continue
+		}
+
+		group := videoGroup{Start: videos[0].LastModified, End: videos[len(videos)-1].LastModified, Videos: videos}
+		message := buildDigestMessage(group, modeMorning, bucketName, cameraViewerURL)
+		keys := make([]string, 0, len(videos))
+		for _, v := range videos {
+			keys = append(keys, v.Key)
+		}
+
+		if err := enqueueNotification(db, ch.Name, message, keys); err != nil {
+			slog.Error("Failed to queue morning digest", "channel", ch.Name, "error", err)
+			continue
+		}
+		for _, id := range ids {
+			if _, err := db.Exec("UPDATE suppressed_videos SET digested = 1 WHERE id = ?", id); err != nil {
+				slog.Error("Failed to mark suppressed video as digested", "id", id, "error", err)
+			}
+		}
+		included += len(videos)
+	}
+	return included
+}
+
+func undigestedVideos(db *sql.DB, channel string, before time.Time) ([]int64, []pendingVideo, error) {
+	rows, err := db.Query(`
+		SELECT id, s3_key, size, last_modified FROM suppressed_videos
+		WHERE channel = ? AND digested = 0 AND last_modified < ?
+		ORDER BY last_modified`, channel, before.UTC())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var videos []pendingVideo
+	for rows.Next() {
+		var id int64
+		var v pendingVideo
+		if err := rows.Scan(&id, &v.Key, &v.Size, &v.LastModified); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		videos = append(videos, v)
+	}
+	return ids, videos, rows.Err()
+}
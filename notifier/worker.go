@@ -0,0 +1,127 @@
+package notifier
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Worker runs the notifier on an interval inside the camera-viewer server
+type Worker struct {
+	notifier *Notifier
+	interval time.Duration
+	trigger  chan struct{}
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	running   bool
+	lastRun   time.Time
+	lastTook  time.Duration
+	lastError string
+	lastRes   RunResult
+}
+
+// Status is the worker state reported by the admin and health endpoints
+type Status struct {
+	Running      bool      `json:"running"`
+	Interval     string    `json:"interval"`
+	LastRun      time.Time `json:"last_run,omitempty"`
+	LastDuration string    `json:"last_duration,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastResult   RunResult `json:"last_result"`
+	Counts       *Counts   `json:"counts,omitempty"`
+}
+
+func NewWorker(notifier *Notifier, interval time.Duration) *Worker {
+	return &Worker{
+		notifier: notifier,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Start runs a check immediately and then every interval until ctx is done
+func (w *Worker) Start(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			w.runOnce(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-w.trigger:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the worker has stopped, after ctx is done
+func (w *Worker) Wait() {
+	w.wg.Wait()
+}
+
+// RunNow asks the worker to check for new videos without waiting for the
+// next tick. It does nothing if a run is already requested.
+func (w *Worker) RunNow() {
+	select {
+	case w.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Worker) runOnce(ctx context.Context) {
+	w.mu.Lock()
+	w.running = true
+	w.mu.Unlock()
+
+	start := time.Now()
+	result, err := w.notifier.Run(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Notifier run failed", "error", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = false
+	w.lastRun = start
+	w.lastTook = time.Since(start)
+	w.lastRes = result
+	w.lastError = ""
+	if err != nil {
+		w.lastError = err.Error()
+	}
+}
+
+// Ping checks that the notifier database can be read
+func (w *Worker) Ping(ctx context.Context) error {
+	return w.notifier.Ping(ctx)
+}
+
+func (w *Worker) Status() Status {
+	w.mu.Lock()
+	status := Status{
+		Running:    w.running,
+		Interval:   w.interval.String(),
+		LastRun:    w.lastRun,
+		LastError:  w.lastError,
+		LastResult: w.lastRes,
+	}
+	if !w.lastRun.IsZero() {
+		status.LastDuration = w.lastTook.Round(time.Millisecond).String()
+	}
+	w.mu.Unlock()
+
+	if counts, err := w.notifier.Counts(); err != nil {
+		slog.Error("Failed to read notifier counts", "error", err)
+	} else {
+		status.Counts = &counts
+	}
+	return status
+}
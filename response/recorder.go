@@ -0,0 +1,46 @@
+package response
+
+import "net/http"
+
+// Recorder notes the status and body size of a response
+type Recorder struct {
+	http.ResponseWriter
+	// Status is the response's status code, 200 until one is written
+	Status int
+	// Bytes counts the body bytes written
+	Bytes int64
+
+	wroteHeader bool
+}
+
+// NewRecorder wraps w
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *Recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses, such as ZIP downloads, flush through
+func (r *Recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the original writer to http.ResponseController
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
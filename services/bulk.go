@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxDeleteBatch is the most keys S3 accepts in one DeleteObjects call
+const maxDeleteBatch = 1000
+
+// KeyError reports an operation that failed for a single key
+type KeyError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// DeleteObjects deletes keys in batches of up to 1000. Keys S3 refuses to
+// delete are returned as failures; err is only set when a whole batch
+// fails, in which case the keys of that batch are reported as failures too.
+func (s *S3Service) DeleteObjects(ctx context.Context, keys []string, progress func(deleted []string, failed []KeyError)) (deleted []string, failed []KeyError, err error) {
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+
+		objects := make([]types.ObjectIdentifier, 0, len(batch))
+		for _, key := range batch {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		result, batchErr := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &s.bucketName,
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(false)},
+		})
+
+		var batchDeleted []string
+		var batchFailed []KeyError
+		if batchErr != nil {
+			for _, key := range batch {
+				batchFailed = append(batchFailed, KeyError{Key: key, Error: batchErr.Error()})
+			}
+			err = fmt.Errorf("failed to delete objects: %w", batchErr)
+		} else {
+			for _, object := range result.Deleted {
+				batchDeleted = append(batchDeleted, aws.ToString(object.Key))
+			}
+			for _, e := range result.Errors {
+				batchFailed = append(batchFailed, KeyError{
+					Key:   aws.ToString(e.Key),
+					Error: fmt.Sprintf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message)),
+				})
+			}
+		}
+
+		s.changed(batchDeleted...)
+		deleted = append(deleted, batchDeleted...)
+		failed = append(failed, batchFailed...)
+		if progress != nil {
+			progress(batchDeleted, batchFailed)
+		}
+		if ctx.Err() != nil {
+			return deleted, failed, ctx.Err()
+		}
+	}
+
+	return deleted, failed, err
+}
+
+// CopyObject copies key from this service's bucket to dstKey in dstBucket,
+// which may be the same bucket. Archived objects must be restored first.
+func (s *S3Service) CopyObject(ctx context.Context, key, dstBucket, dstKey string) error {
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String((&url.URL{Path: s.bucketName + "/" + key}).EscapedPath()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	if dstBucket == s.bucketName {
+		s.changed(dstKey)
+	}
+	return nil
+}
+
+// IsNotFound reports whether err is S3 saying the key does not exist. Some
+// operations, such as GetObjectTagging, only report it as an error code.
+func IsNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey"
+}
@@ -2,9 +2,14 @@ package services
 
 import (
 	"camera-viewer/config"
+	"camera-viewer/logging"
+	"camera-viewer/metrics"
+	"camera-viewer/tracing"
 	"context"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -15,41 +20,97 @@ import (
 type S3Service struct {
 	client     *s3.Client
 	bucketName string
+	onChange   []func(key string)
 }
 
+// Video is a clip object in the bucket
+type Video struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	StorageClass string
+}
+
+// NewS3Service creates a client for the configured bucket, on AWS or on an
+// S3-compatible service when an endpoint is set
 func NewS3Service(cfg *config.Config) (*S3Service, error) {
-	var awsCfg aws.Config
-	var err error
-
-	if cfg.AWSAccessKeyID != "" && cfg.AWSSecretAccessKey != "" {
-		awsCfg, err = awsconfig.LoadDefaultConfig(context.TODO(),
-			awsconfig.WithRegion(cfg.AWSRegion),
-			awsconfig.WithCredentialsProvider(
-				credentials.NewStaticCredentialsProvider(
-					cfg.AWSAccessKeyID,
-					cfg.AWSSecretAccessKey,
-					"",
-				),
+	storage := cfg.Storage
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(storage.Region),
+	}
+	if storage.AccessKeyID != "" && storage.SecretAccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(
+				storage.AccessKeyID,
+				storage.SecretAccessKey,
+				"",
 			),
-		)
-	} else {
-		awsCfg, err = awsconfig.LoadDefaultConfig(context.TODO(),
-			awsconfig.WithRegion(cfg.AWSRegion),
+		))
+	}
+	if storage.DisableChecksums {
+		opts = append(opts,
+			awsconfig.WithRequestChecksumCalculation(aws.RequestChecksumCalculationWhenRequired),
+			awsconfig.WithResponseChecksumValidation(aws.ResponseChecksumValidationWhenRequired),
 		)
 	}
 
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config: %w", err)
 	}
 
-	client := s3.NewFromConfig(awsCfg)
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, metrics.AWSMiddleware, logging.AWSMiddleware, tracing.AWSMiddleware)
+		if storage.Endpoint != "" {
+			o.BaseEndpoint = aws.String(storage.Endpoint)
+		}
+		if storage.ForcePathStyle {
+			o.UsePathStyle = true
+		}
+	})
 
 	return &S3Service{
 		client:     client,
-		bucketName: cfg.BucketName,
+		bucketName: storage.Bucket,
 	}, nil
 }
 
+// HeadBucket checks that the bucket exists and the credentials may use it
+func (s *S3Service) HeadBucket(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: &s.bucketName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to head bucket %s: %w", s.bucketName, err)
+	}
+
+	return nil
+}
+
+// Client exposes the underlying S3 client for callers that need operations
+// the service does not wrap
+func (s *S3Service) Client() *s3.Client {
+	return s.client
+}
+
+func (s *S3Service) BucketName() string {
+	return s.bucketName
+}
+
+// OnChange registers fn to be called with the key of every object the
+// service writes or deletes in its bucket. Register before serving requests.
+func (s *S3Service) OnChange(fn func(key string)) {
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *S3Service) changed(keys ...string) {
+	for _, fn := range s.onChange {
+		for _, key := range keys {
+			fn(key)
+		}
+	}
+}
+
 func (s *S3Service) ListBuckets(ctx context.Context) ([]string, error) {
 	result, err := s.client.ListBuckets(ctx, &s3.ListBucketsInput{})
 	if err != nil {
@@ -89,6 +150,101 @@ func (s *S3Service) ListObjects(ctx context.Context, prefix string) ([]string, e
 	return objects, nil
 }
 
+// ListVideos returns every .mp4 object under prefix, following pagination
+func (s *S3Service) ListVideos(ctx context.Context, prefix string) ([]Video, error) {
+	return s.listObjects(ctx, prefix, true)
+}
+
+// ListAll returns every object under prefix, videos or not, following
+// pagination
+func (s *S3Service) ListAll(ctx context.Context, prefix string) ([]Video, error) {
+	return s.listObjects(ctx, prefix, false)
+}
+
+func (s *S3Service) listObjects(ctx context.Context, prefix string, videosOnly bool) ([]Video, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &s.bucketName,
+	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
+
+	var videos []Video
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			if object.Key == nil || (videosOnly && !strings.HasSuffix(*object.Key, ".mp4")) {
+				continue
+			}
+			video := Video{
+				Key:          *object.Key,
+				Size:         aws.ToInt64(object.Size),
+				StorageClass: string(object.StorageClass),
+			}
+			if object.LastModified != nil {
+				video.LastModified = *object.LastModified
+			}
+			videos = append(videos, video)
+		}
+	}
+
+	return videos, nil
+}
+
+// ListPrefixes returns the "folders" directly below prefix, such as the
+// months of a year
+func (s *S3Service) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    &s.bucketName,
+		Delimiter: aws.String("/"),
+	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
+
+	var prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prefixes: %w", err)
+		}
+		for _, p := range page.CommonPrefixes {
+			if p.Prefix != nil {
+				prefixes = append(prefixes, *p.Prefix)
+			}
+		}
+	}
+
+	return prefixes, nil
+}
+
+// StatVideo reads a single object's metadata without downloading it
+func (s *S3Service) StatVideo(ctx context.Context, key string) (Video, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucketName,
+		Key:    &key,
+	})
+	if err != nil {
+		return Video{}, fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+
+	video := Video{
+		Key:          key,
+		Size:         aws.ToInt64(result.ContentLength),
+		StorageClass: string(result.StorageClass),
+	}
+	if result.LastModified != nil {
+		video.LastModified = *result.LastModified
+	}
+	return video, nil
+}
+
 func (s *S3Service) UploadObject(ctx context.Context, key string, body io.Reader) error {
 	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: &s.bucketName,
@@ -99,6 +255,7 @@ func (s *S3Service) UploadObject(ctx context.Context, key string, body io.Reader
 		return fmt.Errorf("failed to upload object: %w", err)
 	}
 
+	s.changed(key)
 	return nil
 }
 
@@ -123,5 +280,6 @@ func (s *S3Service) DeleteObject(ctx context.Context, key string) error {
 		return fmt.Errorf("failed to delete object: %w", err)
 	}
 
+	s.changed(key)
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,131 @@
+package services_test
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"camera-viewer/services/s3test"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestS3CompatibleEndpoint(t *testing.T) {
+	server := s3test.NewServer(t, "cams")
+	s := s3test.NewService(t, server, config.StorageConfig{
+		Region:           "auto",
+		ForcePathStyle:   true,
+		DisableChecksums: true,
+	})
+	ctx := context.Background()
+
+	if err := s.HeadBucket(ctx); err != nil {
+		t.Fatalf("HeadBucket: %v", err)
+	}
+
+	clips := map[string]string{
+		"2024/01/15/front_door_120000.mp4": "first clip",
+		"2024/01/15/garage_130000.mp4":     "second clip",
+		"2024/01/16/front_door_080000.mp4": "third clip",
+	}
+	for key, body := range clips {
+		if err := s.UploadObject(ctx, key, strings.NewReader(body)); err != nil {
+			t.Fatalf("UploadObject %s: %v", key, err)
+		}
+	}
+	if err := s.UploadObject(ctx, "2024/01/15/notes.txt", strings.NewReader("not a clip")); err != nil {
+		t.Fatalf("UploadObject notes.txt: %v", err)
+	}
+
+	prefixes, err := s.ListPrefixes(ctx, "2024/01/")
+	if err != nil {
+		t.Fatalf("ListPrefixes: %v", err)
+	}
+	if strings.Join(prefixes, ",") != "2024/01/15/,2024/01/16/" {
+		t.Errorf("ListPrefixes = %v, want the two days", prefixes)
+	}
+
+	videos, err := s.ListVideos(ctx, "2024/01/15/")
+	if err != nil {
+		t.Fatalf("ListVideos: %v", err)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("ListVideos returned %d videos, want 2", len(videos))
+	}
+	for _, v := range videos {
+		if v.Size != int64(len(clips[v.Key])) || v.LastModified.IsZero() {
+			t.Errorf("ListVideos %s: size %d, modified %v", v.Key, v.Size, v.LastModified)
+		}
+	}
+
+	key := "2024/01/15/garage_130000.mp4"
+	video, err := s.StatVideo(ctx, key)
+	if err != nil {
+		t.Fatalf("StatVideo: %v", err)
+	}
+	if video.Size != int64(len(clips[key])) {
+		t.Errorf("StatVideo size = %d, want %d", video.Size, len(clips[key]))
+	}
+
+	body, err := s.DownloadObject(ctx, key)
+	if err != nil {
+		t.Fatalf("DownloadObject: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != clips[key] {
+		t.Errorf("DownloadObject = %q, %v; want %q", data, err, clips[key])
+	}
+
+	deleted, failed, err := s.DeleteObjects(ctx, []string{
+		"2024/01/15/front_door_120000.mp4",
+		"2024/01/16/front_door_080000.mp4",
+	}, nil)
+	if err != nil || len(deleted) != 2 || len(failed) != 0 {
+		t.Errorf("DeleteObjects = %v, %v, %v; want both deleted", deleted, failed, err)
+	}
+	if err := s.DeleteObject(ctx, key); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	if _, err := s.StatVideo(ctx, key); !services.IsNotFound(err) {
+		t.Errorf("StatVideo after delete = %v, want not found", err)
+	}
+
+	host := strings.Replace(strings.TrimPrefix(server.URL, "http://"), "127.0.0.1", "localhost", 1)
+	for _, r := range server.Recorded(http.MethodPut) {
+		if r.Host != host || !strings.HasPrefix(r.Path, "/cams/") {
+			t.Errorf("PUT %s on host %s, want path-style on %s", r.Path, r.Host, host)
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "/auto/s3/aws4_request") {
+			t.Errorf("PUT %s signed as %q, want the auto region", r.Path, r.Header.Get("Authorization"))
+		}
+		for name := range r.Header {
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, "x-amz-checksum-") || name == "x-amz-sdk-checksum-algorithm" || name == "x-amz-trailer" {
+				t.Errorf("PUT %s sent %s with checksums disabled", r.Path, name)
+			}
+		}
+	}
+}
+
+func TestS3DefaultChecksums(t *testing.T) {
+	server := s3test.NewServer(t, "cams")
+	s := s3test.NewService(t, server, config.StorageConfig{
+		Region:         "us-east-1",
+		ForcePathStyle: true,
+	})
+
+	if err := s.UploadObject(context.Background(), "2024/01/15/front_door_120000.mp4", strings.NewReader("clip")); err != nil {
+		t.Fatalf("UploadObject: %v", err)
+	}
+
+	puts := server.Recorded(http.MethodPut)
+	if len(puts) != 1 {
+		t.Fatalf("got %d PUT requests, want 1", len(puts))
+	}
+	h := puts[0].Header
+	if h.Get("X-Amz-Checksum-Crc32") == "" && h.Get("X-Amz-Trailer") == "" {
+		t.Errorf("PUT sent no checksum with the default settings: %v", h)
+	}
+}
@@ -0,0 +1,251 @@
+// Package s3test provides a stand-in S3 server for tests
+package s3test
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a minimal MinIO-like S3 server for one bucket. It only serves
+// path-style requests, as MinIO does without a domain configured, and keeps
+// a copy of each request's headers for the tests to inspect.
+type Server struct {
+	*httptest.Server
+	bucket string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+	requests []Request
+}
+
+// Request is a request the server received
+type Request struct {
+	Method string
+	Host   string
+	Path   string
+	Query  string
+	Header http.Header
+}
+
+// NewServer starts a server for bucket, closed when the test ends
+func NewServer(t *testing.T, bucket string) *Server {
+	s := &Server{
+		bucket:   bucket,
+		objects:  map[string][]byte{},
+		modified: map[string]time.Time{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Host:   r.Host,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+	})
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r)
+	case key == "" && r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		s.deleteMany(w, r)
+	case key != "" && r.Method == http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[key] = body
+		s.modified[key] = time.Now().UTC().Truncate(time.Second)
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		body, ok := s.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Header().Set("Last-Modified", s.modified[key].Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	case key != "" && r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		delete(s.modified, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string
+	Prefix         string
+	KeyCount       int
+	MaxKeys        int
+	IsTruncated    bool
+	Contents       []listObject
+	CommonPrefixes []commonPrefix
+}
+
+type listObject struct {
+	Key          string
+	LastModified string
+	Size         int
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{Name: s.bucket, Prefix: prefix, MaxKeys: 1000}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p})
+				}
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, listObject{
+			Key:          key,
+			LastModified: s.modified[key].Format(time.RFC3339),
+			Size:         len(s.objects[key]),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+type deleteRequest struct {
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct {
+		Key string
+	}
+}
+
+func (s *Server) deleteMany(w http.ResponseWriter, r *http.Request) {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var result deleteResult
+	for _, object := range req.Objects {
+		delete(s.objects, object.Key)
+		delete(s.modified, object.Key)
+		result.Deleted = append(result.Deleted, struct{ Key string }{object.Key})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// Recorded returns the requests made so far with the given method
+func (s *Server) Recorded(method string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []Request
+	for _, r := range s.requests {
+		if r.Method == method {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// NewService connects an S3Service to the server, keeping the machine's AWS
+// settings out of the test
+func NewService(t *testing.T, server *Server, storage config.StorageConfig) *services.S3Service {
+	for _, env := range []string{
+		"AWS_PROFILE", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_S3",
+		"AWS_REQUEST_CHECKSUM_CALCULATION", "AWS_RESPONSE_CHECKSUM_VALIDATION",
+	} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+
+	cfg := config.Default()
+	storage.Bucket = server.bucket
+	// A host name rather than 127.0.0.1, which the SDK always addresses
+	// path-style, so the test shows force_path_style is applied
+	storage.Endpoint = strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	storage.AccessKeyID = "minioadmin"
+	storage.SecretAccessKey = "minioadmin"
+	cfg.Storage = storage
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+
+	s, err := services.NewS3Service(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Service: %v", err)
+	}
+	return s
+}
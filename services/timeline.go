@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultClipLength is assumed when a clip's end cannot be derived from
+	// its upload time
+	defaultClipLength = time.Minute
+	// maxClipLength bounds how far after its start a clip's upload time may
+	// be before it is treated as a late upload rather than the clip's end
+	maxClipLength = time.Hour
+	// settleTime is how long after midnight clips recorded just before it
+	// may still be uploaded
+	settleTime = time.Hour
+)
+
+// clipTimestamp matches the recording time cameras put in filenames, e.g.
+// camera_20240101_120000.mp4
+var clipTimestamp = regexp.MustCompile(`(\d{8})[_-]?(\d{6})`)
+
+// Clip is a video placed on the timeline
+type Clip struct {
+	Key          string    `json:"key"`
+	Filename     string    `json:"filename"`
+	Camera       string    `json:"camera"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Size         int64     `json:"size"`
+	StorageClass string    `json:"storageClass"`
+	// Playable is false for clips in Glacier storage classes, which must be
+	// restored before they can be streamed
+	Playable bool `json:"playable"`
+}
+
+// Gap is a period inside the timeline window not covered by any clip
+type Gap struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Timeline lists the clips overlapping a window in recording order
+type Timeline struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Clips []Clip    `json:"clips"`
+	Gaps  []Gap     `json:"gaps"`
+}
+
+// CameraFromKey derives a camera name from a video key. Keys are either
+// YYYY/MM/DD/<camera>/<file>.mp4 or YYYY/MM/DD/<camera>_<timestamp>.mp4.
+func CameraFromKey(key string) string {
+	parts := strings.Split(key, "/")
+	if len(parts) > 4 {
+		return parts[3]
+	}
+
+	name := strings.TrimSuffix(path.Base(key), path.Ext(key))
+	if i := strings.Index(name, "_"); i > 0 {
+		return name[:i]
+	}
+	return "camera"
+}
+
+// ClipStart returns the recording time encoded in a video's filename, read
+// in loc
+func ClipStart(key string, loc *time.Location) (time.Time, bool) {
+	m := clipTimestamp.FindStringSubmatch(path.Base(key))
+	if m == nil {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("20060102150405", m[1]+m[2], loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// DayOf returns the day of the date folder holding key, which may also be a
+// prefix within a day such as 2024/01/15/front/, read in loc
+func DayOf(key string, loc *time.Location) (time.Time, bool) {
+	const layout = "2006/01/02/"
+	if len(key) < len(layout) {
+		return time.Time{}, false
+	}
+	day, err := time.ParseInLocation(layout, key[:len(layout)], loc)
+	return day, err == nil
+}
+
+// DaySettled reports whether every clip of the date folder of day, read in
+// the local time zone, is expected to be uploaded by now
+func DaySettled(day, now time.Time) bool {
+	end := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, time.Local)
+	return now.After(end.Add(settleTime))
+}
+
+// Timeline lists the clips recorded between start and end, optionally for
+// one camera only, along with the gaps of at least minGap between them.
+// Date folders are read in start's location.
+func (s *S3Service) Timeline(ctx context.Context, start, end time.Time, camera string, minGap time.Duration) (*Timeline, error) {
+	loc := start.Location()
+	timeline := &Timeline{Start: start, End: end, Clips: []Clip{}, Gaps: []Gap{}}
+
+	// A clip recorded just before midnight is stored in the previous day's
+	// folder, so the day before the window is listed too
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+	for day := first; day.Before(end); day = day.AddDate(0, 0, 1) {
+		prefix := fmt.Sprintf("%04d/%02d/%02d/", day.Year(), day.Month(), day.Day())
+		videos, err := s.ListVideos(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", prefix, err)
+		}
+
+		for _, video := range videos {
+			clip := NewClip(video, loc)
+			if camera != "" && clip.Camera != camera {
+				continue
+			}
+			if clip.End.After(start) && clip.Start.Before(end) {
+				timeline.Clips = append(timeline.Clips, clip)
+			}
+		}
+	}
+
+	sort.Slice(timeline.Clips, func(i, j int) bool {
+		if timeline.Clips[i].Start.Equal(timeline.Clips[j].Start) {
+			return timeline.Clips[i].Key < timeline.Clips[j].Key
+		}
+		return timeline.Clips[i].Start.Before(timeline.Clips[j].Start)
+	})
+
+	// Walk the clips in order, tracking the end of the covered time so that
+	// overlapping clips from different cameras do not produce gaps
+	covered := start
+	for _, clip := range timeline.Clips {
+		if clip.Start.Sub(covered) >= minGap && clip.Start.After(covered) {
+			timeline.Gaps = append(timeline.Gaps, Gap{Start: covered, End: clip.Start})
+		}
+		if clip.End.After(covered) {
+			covered = clip.End
+		}
+	}
+	if end.Sub(covered) >= minGap && end.After(covered) {
+		timeline.Gaps = append(timeline.Gaps, Gap{Start: covered, End: end})
+	}
+
+	return timeline, nil
+}
+
+// NewClip places a video on the timeline, reading filename timestamps in
+// loc. The recording start comes from the filename and the end from the
+// upload time, since cameras upload a clip once it has finished recording.
+func NewClip(video Video, loc *time.Location) Clip {
+	clip := Clip{
+		Key:          video.Key,
+		Filename:     path.Base(video.Key),
+		Camera:       CameraFromKey(video.Key),
+		Size:         video.Size,
+		StorageClass: video.StorageClass,
+		Playable:     video.StorageClass != "GLACIER" && video.StorageClass != "DEEP_ARCHIVE",
+	}
+	if clip.StorageClass == "" {
+		clip.StorageClass = "STANDARD"
+	}
+
+	uploaded := video.LastModified.In(loc)
+	start, ok := ClipStart(video.Key, loc)
+	if !ok {
+		clip.Start = uploaded.Add(-defaultClipLength)
+		clip.End = uploaded
+		return clip
+	}
+
+	clip.Start = start
+	clip.End = uploaded
+	if !uploaded.After(start) || uploaded.Sub(start) > maxClipLength {
+		clip.End = start.Add(defaultClipLength)
+	}
+	return clip
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	ErrVersionNotFound = errors.New("version not found")
+	ErrDeleteMarker    = errors.New("version is a delete marker")
+)
+
+// ObjectVersion is one version of a key, or a delete marker hiding it
+type ObjectVersion struct {
+	Key            string    `json:"key"`
+	VersionID      string    `json:"versionId"`
+	IsLatest       bool      `json:"isLatest"`
+	IsDeleteMarker bool      `json:"isDeleteMarker"`
+	Size           int64     `json:"size"`
+	LastModified   time.Time `json:"lastModified"`
+	StorageClass   string    `json:"storageClass,omitempty"`
+}
+
+// VersioningStatus returns the bucket's versioning state: "Enabled",
+// "Suspended", or "" if versioning was never turned on
+func (s *S3Service) VersioningStatus(ctx context.Context) (string, error) {
+	result, err := s.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: &s.bucketName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get bucket versioning: %w", err)
+	}
+	return string(result.Status), nil
+}
+
+// ListVersions returns the versions and delete markers under prefix,
+// grouped by key with the newest version first. A positive limit stops the
+// listing once that many are found, reporting whether more were left.
+func (s *S3Service) ListVersions(ctx context.Context, prefix string, limit int) (versions []ObjectVersion, truncated bool, err error) {
+	input := &s3.ListObjectVersionsInput{
+		Bucket: &s.bucketName,
+	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
+
+	for {
+		page, err := s.client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to list object versions: %w", err)
+		}
+
+		for _, v := range page.Versions {
+			version := ObjectVersion{
+				Key:          aws.ToString(v.Key),
+				VersionID:    aws.ToString(v.VersionId),
+				IsLatest:     aws.ToBool(v.IsLatest),
+				Size:         aws.ToInt64(v.Size),
+				StorageClass: string(v.StorageClass),
+			}
+			if v.LastModified != nil {
+				version.LastModified = *v.LastModified
+			}
+			versions = append(versions, version)
+		}
+		for _, m := range page.DeleteMarkers {
+			marker := ObjectVersion{
+				Key:            aws.ToString(m.Key),
+				VersionID:      aws.ToString(m.VersionId),
+				IsLatest:       aws.ToBool(m.IsLatest),
+				IsDeleteMarker: true,
+			}
+			if m.LastModified != nil {
+				marker.LastModified = *m.LastModified
+			}
+			versions = append(versions, marker)
+		}
+
+		if limit > 0 && len(versions) >= limit {
+			truncated = len(versions) > limit || aws.ToBool(page.IsTruncated)
+			break
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.VersionIdMarker = page.NextVersionIdMarker
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	if truncated {
+		versions = versions[:limit]
+	}
+	return versions, truncated, nil
+}
+
+// RestoreVersion makes a previous version of key the current one by copying
+// it over the key. Older versions, including the one restored, are kept.
+func (s *S3Service) RestoreVersion(ctx context.Context, key, versionID string) error {
+	versions, _, err := s.ListVersions(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, v := range versions {
+		if v.Key == key && v.VersionID == versionID {
+			if v.IsDeleteMarker {
+				return ErrDeleteMarker
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrVersionNotFound
+	}
+
+	source := (&url.URL{Path: s.bucketName + "/" + key}).EscapedPath() + "?versionId=" + url.QueryEscape(versionID)
+	_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &s.bucketName,
+		Key:        &key,
+		CopySource: aws.String(source),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore version: %w", err)
+	}
+
+	s.changed(key)
+	return nil
+}
@@ -0,0 +1,227 @@
+package stats
+
+import (
+	"camera-viewer/config"
+	"camera-viewer/metrics"
+	"camera-viewer/services"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Day is the storage used by the clips of one day
+type Day struct {
+	Videos         int            `json:"videos"`
+	SizeBytes      int64          `json:"size_bytes"`
+	StorageClasses map[string]int `json:"storage_classes"`
+
+	usage map[usageKey]*metrics.StorageUsage
+}
+
+type usageKey struct {
+	camera       string
+	storageClass string
+}
+
+// maxCachedDays bounds the cache, at ten years of days
+const maxCachedDays = 3660
+
+type cachedDay struct {
+	day      Day
+	listedAt time.Time
+}
+
+// Collector totals the clips of each day in a range, listing days in
+// parallel. Finished days are cached; today is listed again on every call.
+type Collector struct {
+	s3Service   *services.S3Service
+	concurrency int
+	ttl         time.Duration
+
+	mu   sync.Mutex
+	days map[string]cachedDay
+	// generation counts the days forgotten, so totals listed before a
+	// change are not cached after it
+	generation uint64
+}
+
+func New(cfg config.StatsConfig, s3Service *services.S3Service) *Collector {
+	return &Collector{
+		s3Service:   s3Service,
+		concurrency: cfg.Concurrency,
+		ttl:         cfg.CacheTTL,
+		days:        make(map[string]cachedDay),
+	}
+}
+
+// Collect returns the totals of every day from start to end, both dates
+// included, keyed by YYYY-MM-DD. It fails if a day cannot be listed, and
+// stops with ctx's error when ctx is done. The storage gauges of /metrics are
+// set to the range's totals.
+func (c *Collector) Collect(ctx context.Context, start, end time.Time) (map[string]Day, error) {
+	ctx, span := otel.Tracer("camera-viewer").Start(ctx, "stats.Collect")
+	defer span.End()
+
+	result := make(map[string]Day)
+	var todo []time.Time
+
+	c.mu.Lock()
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		if cached, ok := c.days[date]; ok && time.Since(cached.listedAt) < c.ttl {
+			result[date] = cached.day
+		} else {
+			todo = append(todo, d)
+		}
+	}
+	c.mu.Unlock()
+	span.SetAttributes(
+		attribute.Int("stats.days", len(result)+len(todo)),
+		attribute.Int("stats.days_cached", len(result)),
+	)
+
+	// The first failure cancels the days not listed yet
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan time.Time)
+	var mu sync.Mutex
+	var listErr error
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency && i < len(todo); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				day, err := c.listDay(ctx, d)
+				mu.Lock()
+				if err != nil {
+					if listErr == nil && ctx.Err() == nil {
+						listErr = fmt.Errorf("failed to list %s: %w", d.Format("2006-01-02"), err)
+						cancel()
+					}
+				} else {
+					result[d.Format("2006-01-02")] = day
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, d := range todo {
+		select {
+		case work <- d:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if listErr != nil {
+		return nil, listErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	total := make(map[usageKey]*metrics.StorageUsage)
+	for _, day := range result {
+		for k, u := range day.usage {
+			if total[k] == nil {
+				total[k] = &metrics.StorageUsage{Camera: u.Camera, StorageClass: u.StorageClass}
+			}
+			total[k].Clips += u.Clips
+			total[k].Bytes += u.Bytes
+		}
+	}
+	usage := make([]metrics.StorageUsage, 0, len(total))
+	for _, u := range total {
+		usage = append(usage, *u)
+	}
+	metrics.SetStorage(usage)
+
+	return result, nil
+}
+
+// Forget drops the cached totals of the day holding key, such as a clip
+// deleted through the server
+func (c *Collector) Forget(key string) {
+	day, err := time.Parse("2006/01/02/", key[:min(len(key), len("2006/01/02/"))])
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.days, day.Format("2006-01-02"))
+	c.generation++
+	c.mu.Unlock()
+}
+
+// listDay totals the clips of one day, caching the result once the day has
+// settled
+func (c *Collector) listDay(ctx context.Context, d time.Time) (Day, error) {
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+	listedAt := time.Now()
+	videos, err := c.s3Service.ListVideos(ctx, d.Format("2006/01/02/"))
+	if err != nil {
+		return Day{}, err
+	}
+
+	day := Day{
+		StorageClasses: make(map[string]int),
+		usage:          make(map[usageKey]*metrics.StorageUsage),
+	}
+	for _, video := range videos {
+		day.Videos++
+		day.SizeBytes += video.Size
+		storageClass := video.StorageClass
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
+		day.StorageClasses[storageClass]++
+
+		k := usageKey{camera: services.CameraFromKey(video.Key), storageClass: storageClass}
+		if day.usage[k] == nil {
+			day.usage[k] = &metrics.StorageUsage{Camera: k.camera, StorageClass: k.storageClass}
+		}
+		day.usage[k].Clips++
+		day.usage[k].Bytes += video.Size
+	}
+
+	if services.DaySettled(d, listedAt) {
+		c.mu.Lock()
+		if generation == c.generation {
+			if len(c.days) >= maxCachedDays {
+				c.evict()
+			}
+			c.days[d.Format("2006-01-02")] = cachedDay{day: day, listedAt: listedAt}
+		}
+		c.mu.Unlock()
+	}
+	return day, nil
+}
+
+// evict drops the expired days, or else the one listed longest ago. c.mu
+// must be held.
+func (c *Collector) evict() {
+	var oldest string
+	for date, cached := range c.days {
+		if time.Since(cached.listedAt) >= c.ttl {
+			delete(c.days, date)
+			continue
+		}
+		if oldest == "" || cached.listedAt.Before(c.days[oldest].listedAt) {
+			oldest = date
+		}
+	}
+	if len(c.days) >= maxCachedDays && oldest != "" {
+		delete(c.days, oldest)
+	}
+}
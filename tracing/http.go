@@ -0,0 +1,29 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware traces each request as a span named after the mux route that
+// serves it, such as "GET /stats". The S3 calls and other spans started
+// from the request's context become its children.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	tagged := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.route", route))
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(tagged, "http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			return r.Method + " " + route
+		}),
+	)
+}
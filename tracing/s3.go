@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AWSMiddleware traces the S3 calls of a client as client spans named
+// "S3.<Operation>"; add it to the client's APIOptions. Like the metrics, it
+// wraps the deserialize step, so each retry is its own span and presigning
+// URLs, which never sends a request, is not traced.
+func AWSMiddleware(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("CameraViewerTracing",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			operation := awsmiddleware.GetOperationName(ctx)
+			ctx, span := otel.Tracer(instrumentation).Start(ctx, "S3."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("rpc.system", "aws-api"),
+					attribute.String("rpc.service", "S3"),
+					attribute.String("rpc.method", operation),
+				),
+			)
+			defer span.End()
+
+			out, md, err := next.HandleDeserialize(ctx, in)
+
+			if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+				span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			}
+			if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+				span.SetAttributes(attribute.String("aws.request_id", id))
+			}
+			if err != nil {
+				var apiErr smithy.APIError
+				if errors.As(err, &apiErr) {
+					span.SetAttributes(attribute.String("aws.error_code", apiErr.ErrorCode()))
+				}
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return out, md, err
+		}), middleware.Before)
+}
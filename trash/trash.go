@@ -0,0 +1,273 @@
+package trash
+
+import (
+	"camera-viewer/clipindex"
+	"camera-viewer/config"
+	"camera-viewer/services"
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// purgeInterval is how often expired clips are removed
+const purgeInterval = time.Hour
+
+var (
+	ErrNotInTrash = errors.New("clip is not in the trash")
+	ErrExists     = errors.New("a clip already exists at the original key")
+)
+
+// Item is a deleted clip waiting in the trash
+type Item struct {
+	Key       string    `json:"key"`
+	TrashKey  string    `json:"trash_key"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// Bin soft-deletes clips by moving them below a trash prefix in the same
+// bucket, where they stay until restored or purged. The copy's upload time
+// records when a clip was deleted.
+type Bin struct {
+	s3Service *services.S3Service
+	prefix    string
+	retention time.Duration
+	wg        sync.WaitGroup
+}
+
+func New(cfg config.TrashConfig, s3Service *services.S3Service) *Bin {
+	return &Bin{
+		s3Service: s3Service,
+		prefix:    cfg.Prefix,
+		retention: cfg.Retention,
+	}
+}
+
+// Prefix is the key prefix holding deleted clips
+func (b *Bin) Prefix() string {
+	return b.prefix
+}
+
+// Start purges expired clips every hour until ctx is done
+func (b *Bin) Start(ctx context.Context) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			if n, err := b.Purge(ctx); err != nil {
+				slog.Error("Failed to purge trash", "error", err)
+			} else if n > 0 {
+				slog.Info("Purged clips from the trash", "count", n)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the purge loop has stopped, after ctx is done
+func (b *Bin) Wait() {
+	b.wg.Wait()
+}
+
+// Delete moves keys to the trash along with their sidecars. Annotations go
+// with them: S3 object tags are copied with the clip, and local ones stay
+// under the original key. Keys that could not be moved are returned as
+// failures and left in place.
+func (b *Bin) Delete(ctx context.Context, keys []string) (trashed []string, failed []services.KeyError) {
+	failed = []services.KeyError{}
+	var copied []string
+	// sidecars holds the sidecar of each copied clip that has one
+	sidecars := make(map[string]string)
+	for _, key := range keys {
+		if strings.HasPrefix(key, b.prefix) {
+			failed = append(failed, services.KeyError{Key: key, Error: "already in the trash"})
+			continue
+		}
+		sidecar, err := b.copySidecar(ctx, key, b.prefix+key)
+		if err != nil {
+			failed = append(failed, services.KeyError{Key: key, Error: err.Error()})
+			continue
+		}
+		if err := b.s3Service.CopyObject(ctx, key, b.s3Service.BucketName(), b.prefix+key); err != nil {
+			if sidecar != "" {
+				b.s3Service.DeleteObject(ctx, b.prefix+sidecar)
+			}
+			failed = append(failed, services.KeyError{Key: key, Error: err.Error()})
+			continue
+		}
+		copied = append(copied, key)
+		if sidecar != "" {
+			sidecars[key] = sidecar
+		}
+	}
+
+	trashed, deleteFailed, _ := b.s3Service.DeleteObjects(ctx, copied, nil)
+	if trashed == nil {
+		trashed = []string{}
+	}
+	for _, f := range deleteFailed {
+		// Drop the copies so the clip is not both live and in the trash
+		b.s3Service.DeleteObject(ctx, b.prefix+f.Key)
+		if sidecar, ok := sidecars[f.Key]; ok {
+			b.s3Service.DeleteObject(ctx, b.prefix+sidecar)
+		}
+		failed = append(failed, f)
+	}
+
+	var moved []string
+	for _, key := range trashed {
+		if sidecar, ok := sidecars[key]; ok {
+			moved = append(moved, sidecar)
+		}
+	}
+	if _, sidecarFailed, _ := b.s3Service.DeleteObjects(ctx, moved, nil); len(sidecarFailed) > 0 {
+		slog.WarnContext(ctx, "Failed to delete sidecars of trashed clips", "count", len(sidecarFailed), "key", sidecarFailed[0].Key, "error", sidecarFailed[0].Error)
+	}
+	return trashed, failed
+}
+
+// copySidecar copies the sidecar of the clip at from, if it has one, to be
+// the sidecar of to. It returns the key copied, or "" without a sidecar.
+func (b *Bin) copySidecar(ctx context.Context, from, to string) (string, error) {
+	if !strings.HasSuffix(from, ".mp4") {
+		return "", nil
+	}
+	sidecar := clipindex.SidecarKey(from)
+	if _, err := b.s3Service.StatVideo(ctx, sidecar); err != nil {
+		if services.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if err := b.s3Service.CopyObject(ctx, sidecar, b.s3Service.BucketName(), clipindex.SidecarKey(to)); err != nil {
+		return "", err
+	}
+	return sidecar, nil
+}
+
+// List returns the clips in the trash, most recently deleted first. The
+// sidecars kept with them are not listed.
+func (b *Bin) List(ctx context.Context) ([]Item, error) {
+	objects, err := b.s3Service.ListAll(ctx, b.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	clips := make(map[string]bool)
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, ".mp4") {
+			clips[clipindex.SidecarKey(object.Key)] = true
+		}
+	}
+
+	items := make([]Item, 0, len(objects))
+	for _, object := range objects {
+		if clips[object.Key] {
+			continue
+		}
+		items = append(items, Item{
+			Key:       strings.TrimPrefix(object.Key, b.prefix),
+			TrashKey:  object.Key,
+			Size:      object.Size,
+			DeletedAt: object.LastModified,
+			PurgeAt:   object.LastModified.Add(b.retention),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// Restore moves a clip and its sidecar from the trash back to its original
+// key. It fails with ErrExists rather than overwrite a clip uploaded since.
+func (b *Bin) Restore(ctx context.Context, key string) error {
+	if _, err := b.s3Service.StatVideo(ctx, b.prefix+key); err != nil {
+		if services.IsNotFound(err) {
+			return ErrNotInTrash
+		}
+		return err
+	}
+	if _, err := b.s3Service.StatVideo(ctx, key); err == nil {
+		return ErrExists
+	} else if !services.IsNotFound(err) {
+		return err
+	}
+
+	sidecar, err := b.copySidecar(ctx, b.prefix+key, key)
+	if err != nil {
+		return err
+	}
+	if err := b.s3Service.CopyObject(ctx, b.prefix+key, b.s3Service.BucketName(), key); err != nil {
+		return err
+	}
+	if sidecar != "" {
+		if err := b.s3Service.DeleteObject(ctx, sidecar); err != nil {
+			return err
+		}
+	}
+	return b.s3Service.DeleteObject(ctx, b.prefix+key)
+}
+
+// Remove permanently deletes clips, and their sidecars, from the trash
+func (b *Bin) Remove(ctx context.Context, keys []string) (removed []string, failed []services.KeyError, err error) {
+	trashKeys := make([]string, 0, 2*len(keys))
+	requested := make(map[string]bool)
+	for _, key := range keys {
+		trashKeys = append(trashKeys, b.prefix+key)
+		requested[b.prefix+key] = true
+		if strings.HasSuffix(key, ".mp4") {
+			trashKeys = append(trashKeys, b.prefix+clipindex.SidecarKey(key))
+		}
+	}
+
+	deleted, deleteFailed, err := b.s3Service.DeleteObjects(ctx, trashKeys, nil)
+	removed = []string{}
+	failed = []services.KeyError{}
+	for _, key := range deleted {
+		if requested[key] {
+			removed = append(removed, strings.TrimPrefix(key, b.prefix))
+		}
+	}
+	for _, f := range deleteFailed {
+		f.Key = strings.TrimPrefix(f.Key, b.prefix)
+		failed = append(failed, f)
+	}
+	return removed, failed, err
+}
+
+// Purge permanently deletes clips and sidecars that have been in the trash
+// for longer than the retention period
+func (b *Bin) Purge(ctx context.Context) (int, error) {
+	objects, err := b.s3Service.ListAll(ctx, b.prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var expired []string
+	for _, object := range objects {
+		if now.After(object.LastModified.Add(b.retention)) {
+			expired = append(expired, object.Key)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	deleted, _, err := b.s3Service.DeleteObjects(ctx, expired, nil)
+	return len(deleted), err
+}